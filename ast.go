@@ -0,0 +1,149 @@
+package qs
+
+// Syntax tree produced by ParseTree.
+//
+// The tree mirrors the query string as written, rather than the bleve
+// query it compiles to: prefixes, NOTs, fields and boosts all get their own
+// nodes, and every node records the span of the input it was parsed from.
+
+// Node is an element of a parsed query.
+type Node interface {
+	// Pos returns the byte offset of the start of the node in the input
+	Pos() int
+	// End returns the byte offset just past the end of the node
+	End() int
+	node()
+}
+
+// Span is a range of byte offsets within a query string.
+// Nodes created by code rather than by the parser have a zero Span.
+type Span struct {
+	From int // offset of first byte
+	To   int // offset just past the last byte
+}
+
+func (s Span) Pos() int { return s.From }
+func (s Span) End() int { return s.To }
+
+// List is a sequence of clauses, combined using the default operator.
+// It's the root of every parsed query, and the contents of every Group.
+//   exprList = expr1*
+type List struct {
+	Span
+	Clauses []Node
+}
+
+// BoolExpr is a chain of clauses joined by OR or by AND.
+//   expr1 = expr2 {"OR" expr2}
+//   expr2 = expr3 {"AND" expr3}
+type BoolExpr struct {
+	Span
+	Op       OpType
+	Operands []Node
+}
+
+// NotExpr is a clause negated by NOT.
+//   expr3 = {"NOT"} expr4
+type NotExpr struct {
+	Span
+	X Node
+}
+
+// PrefixExpr is a clause marked as required ('+') or prohibited ('-').
+//   expr4 = {("+"|"-")} expr5
+type PrefixExpr struct {
+	Span
+	Op rune // '+' or '-'
+	X  Node
+}
+
+// FieldExpr restricts a clause to a single field.
+//   field = lit ":"
+type FieldExpr struct {
+	Span
+	Field string
+	X     Node
+}
+
+// BoostExpr applies a boost to a clause.
+//   boost = "^" number
+type BoostExpr struct {
+	Span
+	X     Node
+	Boost float64
+	// BoostPos is the position of the '^'
+	BoostPos int
+}
+
+// Group is a parenthesised sub-query.
+//   "(" exprList ")"
+type Group struct {
+	Span
+	X *List
+}
+
+// MacroExpr is a reference to a macro, along with its expansion.
+// The positions of the nodes in X are relative to the macro's definition,
+// not the query containing the reference.
+//   macro = "@" name {"(" {lit} ")"}
+type MacroExpr struct {
+	Span
+	Name string
+	// Args is nil if the reference has no parentheses (@a), and empty for
+	// @a()
+	Args []string
+	X    *List
+}
+
+// TermsLookup matches any of the terms in an externally-held list.
+// The list is fetched from Parser.Lists when the tree is compiled.
+//   lookup = "@list(" lit ")"
+type TermsLookup struct {
+	Span
+	Name string
+}
+
+// Term is a single unquoted word, which might contain wildcards or have a
+// fuzziness suffix.
+//   lit {"~" number}
+type Term struct {
+	Span
+	Text string
+	// Fuzzy is set if the term had a '~' suffix, in which case Fuzziness
+	// holds the edit distance.
+	Fuzzy     bool
+	Fuzziness int
+}
+
+// Phrase is a quoted string. Text excludes the quotes.
+type Phrase struct {
+	Span
+	Text string
+}
+
+// RangeExpr is a range of numbers or dates, written either in brackets or
+// using a relational operator. An empty Min or Max means the range is open
+// at that end, in which case the corresponding Inclusive flag is ignored.
+//   range = ("["|"{") {lit} "TO" {lit} ("]"|"}")
+//   relational = ("<"|">"|"<="|">=") lit
+type RangeExpr struct {
+	Span
+	Min, Max                   string
+	MinInclusive, MaxInclusive bool
+	// MinQuoted and MaxQuoted are set if the endpoints were written in
+	// quotes (so "$x" is a value, not a template parameter)
+	MinQuoted, MaxQuoted bool
+}
+
+func (*List) node()        {}
+func (*BoolExpr) node()    {}
+func (*NotExpr) node()     {}
+func (*PrefixExpr) node()  {}
+func (*FieldExpr) node()   {}
+func (*BoostExpr) node()   {}
+func (*Group) node()       {}
+func (*MacroExpr) node()   {}
+func (*TermsLookup) node() {}
+func (*Term) node()        {}
+func (*Phrase) node()      {}
+func (*RangeExpr) node()   {}
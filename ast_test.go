@@ -0,0 +1,103 @@
+package qs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTree(t *testing.T) {
+	tree, err := ParseTree(`tags:(lemon -mango)^2 OR NOT "navel orange" x:>=10`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &List{
+		Span: Span{0, 50},
+		Clauses: []Node{
+			&BoolExpr{
+				Span: Span{0, 43},
+				Op:   OR,
+				Operands: []Node{
+					&BoostExpr{
+						Span: Span{0, 21},
+						X: &FieldExpr{
+							Span:  Span{0, 19},
+							Field: "tags",
+							X: &Group{
+								Span: Span{5, 19},
+								X: &List{
+									Span: Span{6, 18},
+									Clauses: []Node{
+										&Term{Span: Span{6, 11}, Text: "lemon"},
+										&PrefixExpr{Span: Span{12, 18}, Op: '-', X: &Term{Span: Span{13, 18}, Text: "mango"}},
+									},
+								},
+							},
+						},
+						Boost:    2,
+						BoostPos: 19,
+					},
+					&NotExpr{Span: Span{25, 43}, X: &Phrase{Span: Span{29, 43}, Text: "navel orange"}},
+				},
+			},
+			&FieldExpr{
+				Span:  Span{44, 50},
+				Field: "x",
+				X:     &RangeExpr{Span: Span{46, 50}, Min: "10", MinInclusive: true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(tree, expected) {
+		t.Errorf("Expected\n%#v\ngot\n%#v", expected, tree)
+	}
+}
+
+func TestParseTreeSpans(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`colour~2`, `colour~2`},
+		{`  wibble  `, `wibble`},
+		{`n:{1 TO 5]^3`, `n:{1 TO 5]^3`},
+		{`(a b)`, `(a b)`},
+	}
+	for _, dat := range data {
+		tree, err := ParseTree(dat.input)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		got := dat.input[tree.Pos():tree.End()]
+		if got != dat.expected {
+			t.Errorf("Expected span `%s`, got `%s`", dat.expected, got)
+		}
+	}
+}
+
+// errors in meaning rather than syntax are reported by Compile
+func TestCompileErrors(t *testing.T) {
+	data := []struct {
+		input string
+		pos   int
+	}{
+		{`foo:(bar:baz)`, 5},
+		{`x:[a TO b]`, 2},
+		{`x:>wibble`, 2},
+	}
+	for _, dat := range data {
+		tree, err := ParseTree(dat.input)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		p := Parser{}
+		_, err = p.Compile(tree)
+		pe, ok := err.(ParseError)
+		if !ok {
+			t.Errorf("`%s`: expected ParseError, got %v", dat.input, err)
+			continue
+		}
+		if pe.Pos != dat.pos {
+			t.Errorf("`%s`: expected error at %d, got %d", dat.input, dat.pos, pe.Pos)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// idQuery returns a query of the form id:(0 1 2 ... n-1)
+func idQuery(n int) string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+	return "id:(" + strings.Join(ids, " ") + ")"
+}
+
+func keywordMapping() *mapping.IndexMappingImpl {
+	m := bleve.NewIndexMapping()
+	fm := bleve.NewTextFieldMapping()
+	fm.Analyzer = keyword.Name
+	m.DefaultMapping.AddFieldMappingsAt("id", fm)
+	return m
+}
+
+var benchSizes = []int{100, 10000, 50000}
+
+func BenchmarkLexIDs(b *testing.B) {
+	for _, n := range benchSizes {
+		q := idQuery(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			var l lexer
+			for i := 0; i < b.N; i++ {
+				l.init(q)
+				for l.nextToken().typ != tEOF {
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseTree(b *testing.B) {
+	for _, n := range benchSizes {
+		q := idQuery(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseTree(q); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// compare compiling a big ID list on an analysed field (one MatchPhraseQuery
+// per value) with a keyword field (a single TermSetQuery)
+func BenchmarkParseIDs(b *testing.B) {
+	for _, kind := range []string{"text", "keyword"} {
+		p := Parser{}
+		if kind == "keyword" {
+			p.Mapping = keywordMapping()
+		}
+		for _, n := range benchSizes {
+			q := idQuery(n)
+			b.Run(kind+"/"+strconv.Itoa(n), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := p.Parse(q); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// search cost of the two forms, against a small index
+func BenchmarkSearchIDs(b *testing.B) {
+	m := keywordMapping()
+	idx, err := bleve.NewMemOnly(m)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer idx.Close()
+	for i := 0; i < 1000; i++ {
+		id := strconv.Itoa(i * 7)
+		if err := idx.Index(id, map[string]string{"id": id}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	q := idQuery(5000)
+	for _, kind := range []string{"text", "keyword"} {
+		p := Parser{}
+		if kind == "keyword" {
+			p.Mapping = m
+		}
+		query, err := p.Parse(q)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(kind, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := idx.Search(bleve.NewSearchRequest(query)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// typical short queries, as seen by a search front end
+var shortQueries = []string{
+	`grapefruit`,
+	`+tags:citrus -"navel orange"^2 colour~1`,
+	`(shaddock OR pomelo) AND pubdate:[2010-01-01 TO 2011-01-01}`,
+}
+
+func BenchmarkLexShort(b *testing.B) {
+	b.ReportAllocs()
+	var l lexer
+	for i := 0; i < b.N; i++ {
+		for _, q := range shortQueries {
+			l.init(q)
+			for l.nextToken().typ != tEOF {
+			}
+		}
+	}
+}
+
+func BenchmarkParseTreeShort(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, q := range shortQueries {
+			if _, err := ParseTree(q); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -43,13 +43,15 @@ import (
 )
 
 var defaultAND bool
+var colour bool
 
 func main() {
 
 	flag.BoolVar(&defaultAND, "a", false, `Require all terms to match (implied AND rather than OR in queries like "foo bar")`)
+	flag.BoolVar(&colour, "c", isTerminal(os.Stderr), `Highlight errors using ANSI colour codes (default is to use colour if stderr is a terminal)`)
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-a] query...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-a] [-c] query...\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Parses a query string and dumps the output to stdout.\noptions:\n")
 		flag.PrintDefaults()
 	}
@@ -66,8 +68,12 @@ func main() {
 	q, err := parser.Parse(queryString)
 	if err != nil {
 		pe := err.(qs.ParseError)
+		shown := queryString
+		if colour {
+			shown = qs.ANSI(queryString, err)
+		}
 		fmt.Fprintf(os.Stderr, "%s\n%s^\n",
-			queryString,
+			shown,
 			strings.Repeat("-", pe.Pos))
 		fmt.Fprintf(os.Stderr, "ERR: %s\n", pe)
 		os.Exit(2)
@@ -82,3 +88,12 @@ func main() {
 	fmt.Println(string(enc))
 
 }
+
+// isTerminal returns true if f looks like a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,365 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/search/query"
+	"strconv"
+	"time"
+)
+
+// Building queries programmatically.
+//
+// A Builder holds a syntax tree (without source positions). Format renders
+// it as a query string, and compiling it gives exactly the same bleve query
+// as parsing that string would, eg:
+//
+//   q := qs.Field("tags").Term("citrus").And(
+//       qs.Range("date").From(t1).ToExclusive(t2)).Boost(2)
+//   q.String()  // "(tags:citrus AND date:[2015-01-01 TO 2015-02-01})^2"
+//   q.Query()   // same as qs.Parse(q.String())
+//
+// Builders are immutable - every method returns a new Builder, so partial
+// queries can be shared and extended freely.
+//
+// Mistakes (bad field names, unsupported range values etc) are recorded
+// and reported by Err, Query and Compile rather than at each step.
+
+// Builder is a query under construction.
+type Builder struct {
+	node Node
+	err  error
+}
+
+// FieldBuilder creates clauses which apply to a single field.
+// It is returned by Field and DefaultField.
+type FieldBuilder struct {
+	field string
+	err   error
+}
+
+// Field starts a clause which applies to the named field.
+func Field(name string) *FieldBuilder {
+	fb := &FieldBuilder{field: name}
+	if !isBareLiteral(name) {
+		fb.err = fmt.Errorf("invalid field name '%s'", name)
+	}
+	return fb
+}
+
+// DefaultField starts a clause which applies to the default field.
+func DefaultField() *FieldBuilder {
+	return &FieldBuilder{}
+}
+
+// wrap applies the field (if any) to n
+func (fb *FieldBuilder) wrap(n Node, err error) *Builder {
+	if fb.err != nil {
+		return &Builder{err: fb.err}
+	}
+	if err != nil {
+		return &Builder{err: err}
+	}
+	if fb.field != "" {
+		n = &FieldExpr{Field: fb.field, X: n}
+	}
+	return &Builder{node: n}
+}
+
+// Term matches a single term. Any wildcard characters in text are matched
+// literally. Terms which can't be written bare (eg containing spaces or
+// special characters) are quoted.
+func (fb *FieldBuilder) Term(text string) *Builder {
+	if text == "" {
+		return fb.wrap(nil, fmt.Errorf("empty term"))
+	}
+	return fb.wrap(literal(text), nil)
+}
+
+// Phrase matches a sequence of terms.
+func (fb *FieldBuilder) Phrase(text string) *Builder {
+	return fb.wrap(&Phrase{Text: text}, nil)
+}
+
+// Wildcard matches terms against a pattern, where '*' matches any
+// sequence of characters and '?' matches any single character.
+func (fb *FieldBuilder) Wildcard(pattern string) *Builder {
+	if !isBareLiteral(pattern) {
+		return fb.wrap(nil, fmt.Errorf("invalid wildcard pattern '%s'", pattern))
+	}
+	return fb.wrap(&Term{Text: pattern}, nil)
+}
+
+// Fuzzy matches terms within the given edit distance of text.
+func (fb *FieldBuilder) Fuzzy(text string, fuzziness int) *Builder {
+	if !isBareLiteral(text) || containsWildcard(text) {
+		return fb.wrap(nil, fmt.Errorf("invalid fuzzy term '%s'", text))
+	}
+	if fuzziness < 0 {
+		return fb.wrap(nil, fmt.Errorf("bad fuzziness %d", fuzziness))
+	}
+	return fb.wrap(&Term{Text: text, Fuzzy: true, Fuzziness: fuzziness}, nil)
+}
+
+// AnyOf matches any of the given terms, eg tags:(lemon OR lime).
+func (fb *FieldBuilder) AnyOf(terms ...string) *Builder {
+	return fb.group(OR, terms)
+}
+
+// AllOf matches all of the given terms, eg tags:(lemon AND lime).
+func (fb *FieldBuilder) AllOf(terms ...string) *Builder {
+	return fb.group(AND, terms)
+}
+
+func (fb *FieldBuilder) group(op OpType, terms []string) *Builder {
+	if len(terms) == 0 {
+		return fb.wrap(nil, fmt.Errorf("no terms"))
+	}
+	operands := make([]Node, len(terms))
+	for i, text := range terms {
+		if text == "" {
+			return fb.wrap(nil, fmt.Errorf("empty term"))
+		}
+		operands[i] = literal(text)
+	}
+	var x Node = operands[0]
+	if len(operands) > 1 {
+		x = &BoolExpr{Op: op, Operands: operands}
+	}
+	return fb.wrap(&Group{X: &List{Clauses: []Node{x}}}, nil)
+}
+
+// literal returns a node which matches text exactly: a Term if it can be
+// written bare, otherwise a Phrase (which is how it'll be printed).
+func literal(text string) Node {
+	if isBareLiteral(text) && !containsWildcard(text) {
+		return &Term{Text: text}
+	}
+	return &Phrase{Text: text}
+}
+
+func containsWildcard(s string) bool {
+	for _, r := range s {
+		if r == '*' || r == '?' {
+			return true
+		}
+	}
+	return false
+}
+
+// Range starts a numeric or date range on a field. Set the endpoints
+// with From, FromExclusive, To and ToExclusive. A range with neither
+// endpoint set is an error.
+func Range(field string) *Builder {
+	return Field(field).wrap(&RangeExpr{}, nil)
+}
+
+// From sets the inclusive lower bound of a range.
+// See RangeValue for the accepted types.
+func (b *Builder) From(v interface{}) *Builder {
+	return b.setRange(v, true, true)
+}
+
+// FromExclusive sets the exclusive lower bound of a range.
+func (b *Builder) FromExclusive(v interface{}) *Builder {
+	return b.setRange(v, true, false)
+}
+
+// To sets the inclusive upper bound of a range.
+func (b *Builder) To(v interface{}) *Builder {
+	return b.setRange(v, false, true)
+}
+
+// ToExclusive sets the exclusive upper bound of a range.
+func (b *Builder) ToExclusive(v interface{}) *Builder {
+	return b.setRange(v, false, false)
+}
+
+func (b *Builder) setRange(v interface{}, min bool, inclusive bool) *Builder {
+	if b.err != nil {
+		return b
+	}
+	// the range may be wrapped in a field
+	var field *FieldExpr
+	n := b.node
+	if f, ok := n.(*FieldExpr); ok {
+		field, n = f, f.X
+	}
+	r, ok := n.(*RangeExpr)
+	if !ok {
+		return &Builder{err: fmt.Errorf("range bound set on a clause which isn't a range")}
+	}
+	val, err := RangeValue(v)
+	if err != nil {
+		return &Builder{err: err}
+	}
+
+	nr := *r
+	if min {
+		nr.Min, nr.MinInclusive = val, inclusive
+	} else {
+		nr.Max, nr.MaxInclusive = val, inclusive
+	}
+	if field != nil {
+		return &Builder{node: &FieldExpr{Field: field.Field, X: &nr}}
+	}
+	return &Builder{node: &nr}
+}
+
+// RangeValue converts a value into the text used for a range endpoint.
+// Strings are used as-is, integers and floats are formatted as numbers,
+// and a time.Time becomes a date (YYYY-MM-DD) in its own location - the
+// time of day is dropped, as ranges only support day precision.
+// Dates in the compiled query are interpreted using Parser.Loc.
+func RangeValue(v interface{}) (string, error) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case int:
+		s = strconv.FormatInt(int64(v), 10)
+	case int32:
+		s = strconv.FormatInt(int64(v), 10)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case uint:
+		s = strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		s = strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		s = strconv.FormatUint(v, 10)
+	case float32:
+		s = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		s = v.Format("2006-01-02")
+	default:
+		return "", fmt.Errorf("unsupported range value type %T", v)
+	}
+	if s == "" {
+		return "", fmt.Errorf("empty range value")
+	}
+	return s, nil
+}
+
+// And combines the clause with others, matching documents which match
+// them all.
+func (b *Builder) And(others ...*Builder) *Builder {
+	return b.combine(AND, others)
+}
+
+// Or combines the clause with others, matching documents which match
+// any of them.
+func (b *Builder) Or(others ...*Builder) *Builder {
+	return b.combine(OR, others)
+}
+
+func (b *Builder) combine(op OpType, others []*Builder) *Builder {
+	if len(others) == 0 {
+		return b
+	}
+	operands := []Node{}
+	for _, o := range append([]*Builder{b}, others...) {
+		if o.err != nil {
+			return &Builder{err: o.err}
+		}
+		// flatten, so a.And(b).And(c) gives "a AND b AND c"
+		if be, ok := o.node.(*BoolExpr); ok && be.Op == op {
+			operands = append(operands, be.Operands...)
+		} else {
+			operands = append(operands, o.node)
+		}
+	}
+	return &Builder{node: &BoolExpr{Op: op, Operands: operands}}
+}
+
+// Not negates the clause (NOT x).
+func (b *Builder) Not() *Builder {
+	if b.err != nil {
+		return b
+	}
+	return &Builder{node: &NotExpr{X: b.node}}
+}
+
+// Must marks the clause as required (+x).
+func (b *Builder) Must() *Builder {
+	return b.prefix('+')
+}
+
+// MustNot marks the clause as prohibited (-x).
+func (b *Builder) MustNot() *Builder {
+	return b.prefix('-')
+}
+
+func (b *Builder) prefix(op rune) *Builder {
+	if b.err != nil {
+		return b
+	}
+	return &Builder{node: &PrefixExpr{Op: op, X: b.node}}
+}
+
+// Boost sets the boost value of the clause (x^boost), which must be
+// greater than zero.
+func (b *Builder) Boost(boost float64) *Builder {
+	if b.err != nil {
+		return b
+	}
+	// the parser ignores ^0, so it can't be written
+	if boost <= 0 {
+		return &Builder{err: fmt.Errorf("bad boost value %g", boost)}
+	}
+	return &Builder{node: &BoostExpr{X: b.node, Boost: boost}}
+}
+
+// Err returns the first error encountered while building the query.
+func (b *Builder) Err() error {
+	_, err := b.Node()
+	return err
+}
+
+// Node returns the syntax tree of the query, in the same form ParseTree
+// returns. The nodes have no source positions.
+func (b *Builder) Node() (Node, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	// ranges are built up a bound at a time, so can only be checked once
+	// they're finished
+	var err error
+	Inspect(b.node, func(n Node) bool {
+		if r, ok := n.(*RangeExpr); ok && r.Min == "" && r.Max == "" && err == nil {
+			err = fmt.Errorf("range with neither endpoint set")
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &List{Clauses: []Node{b.node}}, nil
+}
+
+// String renders the query in canonical query string form.
+// It returns "" if there was an error building the query.
+func (b *Builder) String() string {
+	n, err := b.Node()
+	if err != nil {
+		return ""
+	}
+	return Format(n)
+}
+
+// Query compiles the query using the default Parser settings.
+func (b *Builder) Query() (query.Query, error) {
+	var p Parser
+	return b.Compile(&p)
+}
+
+// Compile compiles the query using the settings of p. The result is the
+// same as p.Parse(b.String()).
+func (b *Builder) Compile(p *Parser) (query.Query, error) {
+	n, err := b.Node()
+	if err != nil {
+		return nil, err
+	}
+	return p.Compile(n)
+}
@@ -0,0 +1,134 @@
+package qs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ExampleBuilder() {
+	t1 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2015, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	q := Field("tags").Term("citrus").And(Range("date").From(t1).ToExclusive(t2)).Boost(2)
+	fmt.Println(q)
+	// Output: (tags:citrus AND date:[2015-01-01 TO 2015-02-01})^2
+}
+
+func TestBuilder(t *testing.T) {
+	day := time.Date(2016, 3, 4, 15, 30, 0, 0, time.UTC)
+	data := []struct {
+		b        *Builder
+		expected string
+	}{
+		{DefaultField().Term("grapefruit"), `grapefruit`},
+		{Field("tags").Term("navel orange"), `tags:"navel orange"`},
+		{Field("tags").Term("a*"), `tags:"a*"`},
+		{Field("tags").Term("OR"), `tags:"OR"`},
+		{Field("tags").Term("-x"), `tags:"-x"`},
+		{Field("title").Phrase(`say "hi"`), `title:'say "hi"'`},
+		{Field("title").Phrase(`it's "hi"`), `title:"it's \"hi\""`},
+		{Field("path").Phrase(`C:\Windows\`), `path:"C:\\Windows\\"`},
+		{Field("tags").Wildcard("f??t"), `tags:f??t`},
+		{DefaultField().Fuzzy("colour", 2), `colour~2`},
+		{Field("tags").AnyOf("lemon", "lime", "yuzu fruit"), `tags:(lemon OR lime OR "yuzu fruit")`},
+		{Field("tags").AllOf("lemon"), `tags:(lemon)`},
+		{Range("n").From(1).To(5.5), `n:[1 TO 5.5]`},
+		{Range("n").FromExclusive(-3), `n:>"-3"`},
+		{Range("n").ToExclusive(uint(7)), `n:<7`},
+		{Range("date").From(day).To(day), `date:[2016-03-04 TO 2016-03-04]`},
+		{DefaultField().Term("a").And(DefaultField().Term("b")).And(DefaultField().Term("c")), `a AND b AND c`},
+		{DefaultField().Term("a").Or(DefaultField().Term("b")).And(DefaultField().Term("c")), `(a OR b) AND c`},
+		{DefaultField().Term("a").Or(DefaultField().Term("b")).Not(), `NOT (a OR b)`},
+		{DefaultField().Term("a").Must().Or(Field("f").Term("b").MustNot()), `+a OR -f:b`},
+		{DefaultField().Term("a").MustNot().Not(), `NOT -a`},
+		{Field("f").Term("a").Boost(1.5).Boost(3), `(f:a^1.5)^3`},
+	}
+
+	for _, dat := range data {
+		if err := dat.b.Err(); err != nil {
+			t.Fatalf("`%s`: %s", dat.expected, err)
+		}
+		got := dat.b.String()
+		if got != dat.expected {
+			t.Errorf("Expected `%s`, got `%s`", dat.expected, got)
+			continue
+		}
+
+		// compiling directly should match parsing the rendered string
+		for _, op := range []OpType{OR, AND} {
+			p := &Parser{DefaultOp: op}
+			expected, err := p.Parse(got)
+			if err != nil {
+				t.Fatalf("`%s`: %s", got, err)
+			}
+			q, err := dat.b.Compile(p)
+			if err != nil {
+				t.Fatalf("`%s`: %s", got, err)
+			}
+			if !reflect.DeepEqual(q, expected) {
+				t.Errorf("`%s`: compiled query differs from parsed one", got)
+			}
+		}
+	}
+}
+
+func TestBuilderImmutable(t *testing.T) {
+	base := Field("tags").Term("citrus")
+	a := base.And(DefaultField().Term("a"))
+	b := base.And(DefaultField().Term("b"))
+	r := Range("n").From(1)
+	r1 := r.To(2)
+	r2 := r.To(3)
+	for _, dat := range []struct {
+		b        *Builder
+		expected string
+	}{
+		{base, `tags:citrus`},
+		{a, `tags:citrus AND a`},
+		{b, `tags:citrus AND b`},
+		{r, `n:>=1`},
+		{r1, `n:[1 TO 2]`},
+		{r2, `n:[1 TO 3]`},
+	} {
+		if got := dat.b.String(); got != dat.expected {
+			t.Errorf("Expected `%s`, got `%s`", dat.expected, got)
+		}
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	bad := []*Builder{
+		Field("bad field").Term("x"),
+		Field("").Term("x"),
+		DefaultField().Term(""),
+		DefaultField().Wildcard("a b*"),
+		DefaultField().Fuzzy("a*", 1),
+		DefaultField().AnyOf(),
+		Range("n").From(struct{}{}),
+		Field("f").Term("x").From(1),
+		DefaultField().Term("x").And(Field(":").Term("y")),
+		DefaultField().Term("x").Boost(-1),
+		DefaultField().Term("x").Boost(0),
+		Range("n"),
+		DefaultField().Term("x").Or(Range("n").Boost(2)),
+	}
+	for i, b := range bad {
+		if b.Err() == nil {
+			t.Errorf("%d: expected error, got `%s`", i, b)
+		}
+		if _, err := b.Query(); err == nil {
+			t.Errorf("%d: expected Query() to fail", i)
+		}
+	}
+
+	// errors which only show up at compile time
+	for _, b := range []*Builder{
+		Range("n").From("banana"),
+	} {
+		if _, err := b.Query(); err == nil {
+			t.Errorf("`%s`: expected compile error", b)
+		}
+	}
+}
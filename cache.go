@@ -0,0 +1,250 @@
+package qs
+
+import (
+	"container/list"
+	"github.com/blevesearch/bleve/search/query"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// QueryCache is an LRU cache of compiled queries, for use as Parser.Cache.
+// Entries are keyed by the query string and every Parser setting which
+// affects the result, so a single cache can be shared by differently
+// configured Parsers.
+//
+// bleve queries can be modified (SetBoost, SetField...), so each call
+// hands out its own copy of the cached query.
+//
+// Queries which fail to parse aren't cached, as errors from macros and
+// term lists may be temporary. Macro expansions and term lists are cached
+// along with the query, so call Purge if their definitions change. If
+// Parser.Macros, Lists or Mapping can't be compared (eg a MacroFunc), the
+// cache is bypassed.
+//
+// A QueryCache is safe for use by multiple goroutines.
+type QueryCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*list.Element
+	lru     *list.List // most recently used at the front
+	stats   CacheStats
+}
+
+// CacheStats holds counts of QueryCache activity.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Bypassed counts parses which couldn't use the cache
+	Bypassed uint64
+	// Entries is the number of queries currently held
+	Entries int
+}
+
+type cacheKey struct {
+	q        string
+	op       OpType
+	loc      *time.Location
+	maxDepth int
+	mapping  interface{}
+	macros   interface{}
+	lists    interface{}
+}
+
+type cacheEntry struct {
+	key cacheKey
+	q   query.Query
+}
+
+// mapIdentity stands in for a map-based setting (eg MacroMap), which can't
+// be used in a map key itself.
+type mapIdentity struct {
+	typ reflect.Type
+	ptr uintptr
+}
+
+// NewQueryCache creates a cache holding up to size queries.
+func NewQueryCache(size int) *QueryCache {
+	if size < 1 {
+		size = 1
+	}
+	return &QueryCache{
+		size:    size,
+		entries: make(map[cacheKey]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// Stats returns the cache's activity so far.
+func (c *QueryCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// Purge removes all entries from the cache. The stats are kept.
+func (c *QueryCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[cacheKey]*list.Element, c.size)
+	c.lru.Init()
+}
+
+// parse is Parser.Parse, via the cache.
+func (c *QueryCache) parse(p *Parser, q string) (query.Query, error) {
+	key, ok := p.cacheKey(q)
+	if !ok {
+		c.mu.Lock()
+		c.stats.Bypassed++
+		c.mu.Unlock()
+		return p.parse(q)
+	}
+
+	c.mu.Lock()
+	if el, hit := c.entries[key]; hit {
+		c.lru.MoveToFront(el)
+		c.stats.Hits++
+		cached := el.Value.(*cacheEntry).q
+		c.mu.Unlock()
+		// cached queries are never modified, so can be copied outside the lock
+		return copyQuery(cached), nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	out, err := p.parse(q)
+	if err != nil {
+		return nil, err
+	}
+	cached := copyQuery(out)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, dup := c.entries[key]; dup {
+		// another goroutine got there first
+		c.lru.MoveToFront(el)
+		return out, nil
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, cached})
+	for c.lru.Len() > c.size {
+		old := c.lru.Back()
+		c.lru.Remove(old)
+		delete(c.entries, old.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+	return out, nil
+}
+
+// cacheKey returns the key for parsing q with p's settings, or false if
+// the settings can't be used as a key.
+func (p *Parser) cacheKey(q string) (cacheKey, bool) {
+	key := cacheKey{q: q, op: p.DefaultOp, loc: p.Loc, maxDepth: p.MaxMacroDepth}
+	var ok1, ok2, ok3 bool
+	key.mapping, ok1 = settingIdentity(p.Mapping)
+	key.macros, ok2 = settingIdentity(p.Macros)
+	key.lists, ok3 = settingIdentity(p.Lists)
+	return key, ok1 && ok2 && ok3
+}
+
+// settingIdentity returns something comparable which identifies v.
+// Maps are identified by address. Other uncomparable types (funcs, slices)
+// can't be identified, and nor can structs or arrays holding them in
+// interface fields.
+func settingIdentity(v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+	t := reflect.TypeOf(v)
+	if t.Comparable() {
+		return v, hashable(reflect.ValueOf(v))
+	}
+	if t.Kind() == reflect.Map {
+		return mapIdentity{t, reflect.ValueOf(v).Pointer()}, true
+	}
+	return nil, false
+}
+
+// hashable reports whether v, of a comparable type, can be used as a map
+// key. Comparable types can still hold uncomparable values in interfaces,
+// which panic when hashed.
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return true
+		}
+		return v.Elem().Type().Comparable() && hashable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// copyQuery returns a deep copy of q, so that changes to one don't show up
+// in the other. Unexported fields (eg compiled regexps) are shared.
+func copyQuery(q query.Query) query.Query {
+	if q == nil {
+		return nil
+	}
+	return deepCopy(reflect.ValueOf(q)).Interface().(query.Query)
+}
+
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(deepCopy(v.Elem()))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(deepCopy(v.Elem()))
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			out.SetMapIndex(k, deepCopy(v.MapIndex(k)))
+		}
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := out.Field(i); f.CanSet() {
+				f.Set(deepCopy(v.Field(i)))
+			}
+		}
+		return out
+	}
+	return v
+}
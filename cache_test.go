@@ -0,0 +1,119 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve/search/query"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryCache(t *testing.T) {
+	cache := NewQueryCache(2)
+	p := &Parser{Cache: cache}
+	plain := &Parser{}
+
+	in := `tags:(lemon lime)^2 +pubdate:[2016-01-01 TO 2017-01-01} -re*x`
+	expected, err := plain.Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q1, err := p.Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q2, err := p.Parse(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(q1, expected) || !reflect.DeepEqual(q2, expected) {
+		t.Fatalf("cached query differs from uncached")
+	}
+	if got := cache.Stats(); got != (CacheStats{Hits: 1, Misses: 1, Entries: 1}) {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	// changes to a returned query mustn't leak into the cache
+	clauses := q1.(*query.BooleanQuery).Should.(*query.DisjunctionQuery).Disjuncts
+	clauses[0].(query.BoostableQuery).SetBoost(99)
+	q2.(*query.BooleanQuery).SetBoost(5)
+	q3, _ := p.Parse(in)
+	if !reflect.DeepEqual(q3, expected) {
+		t.Errorf("cached query was modified")
+	}
+
+	// settings which change the result are part of the key
+	others := []*Parser{
+		{Cache: cache, DefaultOp: AND},
+		{Cache: cache, Loc: time.FixedZone("test", 3600)},
+		{Cache: cache, Mapping: keywordMapping()},
+	}
+	for i, other := range others {
+		uncached := *other
+		uncached.Cache = nil
+		want, _ := uncached.Parse(in)
+		got, err := other.Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d: got query for different settings", i)
+		}
+	}
+	// only room for two
+	if got := cache.Stats(); got.Misses != 4 || got.Evictions != 2 || got.Entries != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	// errors aren't cached
+	for i := 0; i < 2; i++ {
+		if _, err := p.Parse(`tags:(oops`); err == nil {
+			t.Errorf("expected error")
+		}
+	}
+	if got := cache.Stats(); got.Misses != 6 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	cache.Purge()
+	if got := cache.Stats(); got.Entries != 0 || got.Hits != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+}
+
+func TestQueryCacheResolvers(t *testing.T) {
+	cache := NewQueryCache(10)
+	macros := MacroMap{"citrus": `lemon OR lime`}
+	p1 := &Parser{Cache: cache, Macros: macros}
+	p2 := &Parser{Cache: cache, Macros: MacroMap{"citrus": `orange`}}
+
+	q1, _ := p1.Parse(`@citrus`)
+	q2, _ := p2.Parse(`@citrus`)
+	if reflect.DeepEqual(q1, q2) {
+		t.Errorf("different macro sets shared an entry")
+	}
+	p1.Parse(`@citrus`)
+	if got := cache.Stats(); got.Hits != 1 || got.Misses != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	// funcs can't be told apart
+	p3 := &Parser{Cache: cache, Macros: testMacros}
+	for i := 0; i < 2; i++ {
+		if _, err := p3.Parse(`@citrus_fruits`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := cache.Stats(); got.Bypassed != 2 || got.Entries != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+
+	// nor can funcs inside comparable structs
+	p4 := &Parser{Cache: cache, Macros: struct{ MacroResolver }{testMacros}}
+	if _, err := p4.Parse(`@citrus_fruits`); err != nil {
+		t.Fatal(err)
+	}
+	if got := cache.Stats(); got.Bypassed != 3 || got.Entries != 2 {
+		t.Errorf("unexpected stats %+v", got)
+	}
+}
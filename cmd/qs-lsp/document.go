@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// document is an open text document
+type document struct {
+	uri        string
+	languageID string
+	text       string
+	// lineStarts holds the byte offset of the start of each line
+	lineStarts []int
+	queries    []embeddedQuery
+}
+
+// embeddedQuery is a query string found within a document
+type embeddedQuery struct {
+	offset int // byte offset of the query within the document
+	text   string
+}
+
+func (q embeddedQuery) end() int { return q.offset + len(q.text) }
+
+func newDocument(uri, languageID, text string) *document {
+	doc := &document{uri: uri, languageID: languageID, text: text}
+	doc.lineStarts = []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			doc.lineStarts = append(doc.lineStarts, i+1)
+		}
+	}
+	doc.queries = doc.findQueries()
+	return doc
+}
+
+// isQSFile returns true if the whole document is made up of queries, rather
+// than having them embedded in fenced blocks.
+func (doc *document) isQSFile() bool {
+	return doc.languageID == "qs" || strings.HasSuffix(doc.uri, ".qs")
+}
+
+// line returns the text of line n, without the line ending
+func (doc *document) line(n int) string {
+	end := len(doc.text)
+	if n+1 < len(doc.lineStarts) {
+		end = doc.lineStarts[n+1]
+	}
+	return strings.TrimRight(doc.text[doc.lineStarts[n]:end], "\r\n")
+}
+
+// findQueries locates the queries in the document.
+// Queries are one per line, skipping blank lines and '#' comments. In .qs
+// files the whole document is scanned, otherwise only fenced code blocks
+// marked as qs, eg:
+//
+//   ```qs
+//   tags:citrus -grapefruit
+//   ```
+func (doc *document) findQueries() []embeddedQuery {
+	queries := []embeddedQuery{}
+	inBlock := doc.isQSFile()
+	fence := ""
+	for n := range doc.lineStarts {
+		line := doc.line(n)
+		trimmed := strings.TrimSpace(line)
+		if !doc.isQSFile() {
+			if fence == "" {
+				for _, f := range []string{"```", "~~~"} {
+					if strings.HasPrefix(trimmed, f) && strings.TrimSpace(trimmed[len(f):]) == "qs" {
+						fence = f
+						inBlock = true
+					}
+				}
+				continue
+			}
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+				inBlock = false
+				continue
+			}
+		}
+		if !inBlock || trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		queries = append(queries, embeddedQuery{offset: doc.lineStarts[n], text: line})
+	}
+	return queries
+}
+
+// queryAt returns the query containing the given offset
+func (doc *document) queryAt(offset int) (embeddedQuery, bool) {
+	for _, q := range doc.queries {
+		if offset >= q.offset && offset <= q.end() {
+			return q, true
+		}
+	}
+	return embeddedQuery{}, false
+}
+
+// position converts a byte offset into an LSP line/character position.
+// LSP counts characters in UTF-16 code units.
+func (doc *document) position(offset int) position {
+	line := 0
+	for line+1 < len(doc.lineStarts) && doc.lineStarts[line+1] <= offset {
+		line++
+	}
+	char := 0
+	for _, r := range doc.text[doc.lineStarts[line]:offset] {
+		char += utf16Len(r)
+	}
+	return position{Line: line, Character: char}
+}
+
+// offset converts an LSP position into a byte offset.
+func (doc *document) offset(pos position) int {
+	if pos.Line >= len(doc.lineStarts) {
+		return len(doc.text)
+	}
+	if pos.Line < 0 {
+		return 0
+	}
+	offset := doc.lineStarts[pos.Line]
+	line := doc.line(pos.Line)
+	char := 0
+	for char < pos.Character && offset-doc.lineStarts[pos.Line] < len(line) {
+		r, w := utf8.DecodeRuneInString(doc.text[offset:])
+		char += utf16Len(r)
+		offset += w
+	}
+	return offset
+}
+
+func (doc *document) span(from, to int) lspRange {
+	return lspRange{Start: doc.position(from), End: doc.position(to)}
+}
+
+// utf16Len returns the number of UTF-16 code units needed to encode r
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
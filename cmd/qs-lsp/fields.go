@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/blevesearch/bleve/mapping"
+	"io/ioutil"
+	"sort"
+)
+
+// fieldInfo describes a field in the index mapping
+type fieldInfo struct {
+	Name string
+	Type string // "text", "number", "datetime", "boolean" or "geopoint"
+}
+
+// loadFields reads the fields out of a bleve index mapping file.
+// As well as a bare mapping, it accepts the index_meta.json file from an
+// index directory, which holds the mapping under "mapping".
+func loadFields(filename string) ([]fieldInfo, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta struct {
+		Mapping json.RawMessage `json:"mapping"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	if len(meta.Mapping) > 0 {
+		data = meta.Mapping
+	}
+
+	m := mapping.NewIndexMapping()
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return mappingFields(m), nil
+}
+
+// mappingFields collects all the named fields in a mapping, across all
+// document types.
+func mappingFields(m *mapping.IndexMappingImpl) []fieldInfo {
+	found := map[string]fieldInfo{}
+	var walk func(path string, dm *mapping.DocumentMapping)
+	walk = func(path string, dm *mapping.DocumentMapping) {
+		if dm == nil {
+			return
+		}
+		for _, fm := range dm.Fields {
+			// a field takes its name from its path in the document, but the
+			// last element can be overridden
+			name := path
+			if fm.Name != "" {
+				name = fm.Name
+				if parent := parentPath(path); parent != "" {
+					name = parent + "." + fm.Name
+				}
+			}
+			if name != "" {
+				found[name] = fieldInfo{Name: name, Type: fm.Type}
+			}
+		}
+		for prop, sub := range dm.Properties {
+			p := prop
+			if path != "" {
+				p = path + "." + prop
+			}
+			walk(p, sub)
+		}
+	}
+	walk("", m.DefaultMapping)
+	for _, dm := range m.TypeMapping {
+		walk("", dm)
+	}
+
+	fields := make([]fieldInfo, 0, len(found))
+	for _, f := range found {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// parentPath strips the last element from a dotted path
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '.' {
+			return path[:i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+// qs-lsp - a Language Server Protocol server for qs query strings.
+//
+// It provides diagnostics, completion, hover and formatting for:
+//
+//   - .qs files, which hold one query per line. Blank lines and lines
+//     beginning with '#' are ignored.
+//   - queries embedded in other documents (eg markdown) in fenced code
+//     blocks marked as "qs".
+//
+// The server talks LSP over stdin/stdout, and never touches the network.
+// Field names and types for completion come from a bleve index mapping file
+// (either a bare mapping, or the index_meta.json file from an index
+// directory), eg:
+//
+//   $ qs-lsp -mapping myindex.bleve/index_meta.json
+//
+// Hovering over part of a query shows the bleve query JSON it compiles to.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bcampbell/qs"
+	"log"
+	"os"
+)
+
+func main() {
+	var mappingFile string
+	var defaultAND bool
+	var logFile string
+
+	flag.StringVar(&mappingFile, "mapping", "", "bleve index mapping file to take field names from")
+	flag.BoolVar(&defaultAND, "a", false, `Require all terms to match (implied AND rather than OR in queries like "foo bar")`)
+	flag.StringVar(&logFile, "log", "", "file to log to (default is stderr)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-a] [-mapping file] [-log file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Language server for qs queries, using LSP over stdin/stdout.\noptions:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	srv := newServer()
+	if defaultAND {
+		srv.parser.DefaultOp = qs.AND
+	}
+	if mappingFile != "" {
+		fields, err := loadFields(mappingFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: %s\n", err)
+			os.Exit(1)
+		}
+		srv.fields = fields
+	}
+
+	err := srv.serve(os.Stdin, os.Stdout)
+	if err != nil {
+		log.Printf("ERR: %s", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/bcampbell/qs"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+type server struct {
+	parser qs.Parser
+	// fields from the index mapping (if any)
+	fields []fieldInfo
+	docs   map[string]*document
+	out    io.Writer
+}
+
+func newServer() *server {
+	return &server{
+		docs: map[string]*document{},
+	}
+}
+
+// serve handles LSP messages until the client sends "exit" or closes the
+// connection.
+func (s *server) serve(r io.Reader, w io.Writer) error {
+	s.out = w
+	br := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(br)
+		if err == io.EOF {
+			return nil
+		}
+		if rerr, ok := err.(*rpcError); ok {
+			s.send(&rpcErrorResponse{JSONRPC: "2.0", Error: rerr})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			// notifications don't get replies
+			if err != nil {
+				log.Printf("%s: %s", msg.Method, err)
+			}
+			continue
+		}
+		if err != nil {
+			rerr, ok := err.(*rpcError)
+			if !ok {
+				rerr = &rpcError{codeInvalidParams, err.Error()}
+			}
+			s.send(&rpcErrorResponse{JSONRPC: "2.0", ID: msg.ID, Error: rerr})
+			continue
+		}
+		s.send(&rpcResponse{JSONRPC: "2.0", ID: msg.ID, Result: result})
+	}
+}
+
+func (s *server) send(msg interface{}) {
+	if err := writeMessage(s.out, msg); err != nil {
+		log.Printf("write failed: %s", err)
+	}
+}
+
+func (s *server) notify(method string, params interface{}) {
+	s.send(&rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *server) handle(msg *rpcRequest) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // full
+				"completionProvider":         map[string]interface{}{"triggerCharacters": []string{":"}},
+				"hoverProvider":              true,
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "qs-lsp"},
+		}, nil
+	case "initialized", "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		td := params.TextDocument
+		s.open(newDocument(td.URI, td.LanguageID, td.Text))
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.doc(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.open(newDocument(doc.uri, doc.languageID, params.ContentChanges[n-1].Text))
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+		return nil, nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params)
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params)
+	case "textDocument/formatting":
+		var params documentFormattingParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.formatting(params)
+	}
+	if msg.ID == nil {
+		// unknown notifications (eg "$/cancelRequest") are ignored
+		return nil, nil
+	}
+	return nil, &rpcError{codeMethodNotFound, fmt.Sprintf("unsupported method %s", msg.Method)}
+}
+
+func (s *server) doc(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, fmt.Errorf("unknown document %s", uri)
+	}
+	return doc, nil
+}
+
+// open adds or replaces a document, and publishes diagnostics for it
+func (s *server) open(doc *document) {
+	s.docs[doc.uri] = doc
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: doc.uri, Diagnostics: s.diagnose(doc)})
+}
+
+func (s *server) diagnose(doc *document) []diagnostic {
+	diags := []diagnostic{}
+	for _, q := range doc.queries {
+		tree, err := s.parser.ParseTree(q.text)
+		if err == nil {
+			_, err = s.parser.Compile(tree)
+		}
+		if pe, ok := err.(qs.ParseError); ok {
+			from, to := errorRange(q.text, pe.Pos)
+			diags = append(diags, diagnostic{
+				Range:    doc.span(q.offset+from, q.offset+to),
+				Severity: severityError,
+				Source:   "qs",
+				Message:  pe.Msg,
+			})
+			continue
+		}
+		if len(s.fields) == 0 {
+			continue
+		}
+		walk(tree, func(n qs.Node) {
+			if f, ok := n.(*qs.FieldExpr); ok && !s.knownField(f.Field) {
+				diags = append(diags, diagnostic{
+					Range:    doc.span(q.offset+f.Pos(), q.offset+f.Pos()+len(f.Field)),
+					Severity: severityWarning,
+					Source:   "qs",
+					Message:  fmt.Sprintf("unknown field '%s'", f.Field),
+				})
+			}
+		})
+	}
+	return diags
+}
+
+// errorRange returns the span of the token at fault for an error at pos
+func errorRange(q string, pos int) (int, int) {
+	for _, tok := range qs.Tokenize(q) {
+		if tok.Kind != qs.TokSpace && tok.Pos <= pos && pos < tok.End {
+			return tok.Pos, tok.End
+		}
+	}
+	return pos, pos
+}
+
+func (s *server) knownField(name string) bool {
+	for _, f := range s.fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) completion(params textDocumentPositionParams) ([]completionItem, error) {
+	doc, err := s.doc(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	items := []completionItem{}
+	offset := doc.offset(params.Position)
+	q, ok := doc.queryAt(offset)
+	if !ok {
+		return items, nil
+	}
+	local := offset - q.offset
+
+	// find the partial word being typed
+	start := local
+	for start > 0 && !strings.ContainsRune(" \t:(){}[]^~\"'", rune(q.text[start-1])) {
+		start--
+	}
+	prefix := strings.TrimLeft(q.text[start:local], "+-")
+
+	if field, ok := fieldInScope(q.text[:start]); ok {
+		for _, v := range s.values(field) {
+			if strings.HasPrefix(strings.ToLower(v), strings.ToLower(prefix)) {
+				items = append(items, completionItem{Label: v, Kind: completionKindValue, Detail: field})
+			}
+		}
+		return items, nil
+	}
+
+	for _, f := range s.fieldNames() {
+		if strings.HasPrefix(strings.ToLower(f.Name), strings.ToLower(prefix)) {
+			items = append(items, completionItem{Label: f.Name + ":", Kind: completionKindField, Detail: f.Type})
+		}
+	}
+	for _, kw := range []string{"AND", "OR", "NOT"} {
+		if prefix != "" && strings.HasPrefix(kw, prefix) {
+			items = append(items, completionItem{Label: kw, Kind: completionKindKeyword})
+		}
+	}
+	return items, nil
+}
+
+// fieldInScope works out which field (if any) applies at the end of a
+// partial query, either directly ("tags:") or via a group ("tags:(lemon ").
+func fieldInScope(q string) (string, bool) {
+	scopes := []string{}
+	var prev [2]qs.Token // last two non-space tokens
+	for _, tok := range qs.Tokenize(q) {
+		if tok.Kind == qs.TokSpace {
+			continue
+		}
+		switch tok.Kind {
+		case qs.TokLParen:
+			field := ""
+			if prev[1].Kind == qs.TokColon && prev[0].Kind == qs.TokLiteral {
+				field = prev[0].Text
+			}
+			scopes = append(scopes, field)
+		case qs.TokRParen:
+			if len(scopes) > 0 {
+				scopes = scopes[:len(scopes)-1]
+			}
+		}
+		prev[0], prev[1] = prev[1], tok
+	}
+	if prev[1].Kind == qs.TokColon && prev[0].Kind == qs.TokLiteral {
+		return prev[0].Text, true
+	}
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if scopes[i] != "" {
+			return scopes[i], true
+		}
+	}
+	return "", false
+}
+
+// fieldNames returns the fields to offer for completion: those in the
+// mapping if there is one, otherwise those used in the open documents.
+func (s *server) fieldNames() []fieldInfo {
+	if len(s.fields) > 0 {
+		return s.fields
+	}
+	seen := map[string]bool{}
+	fields := []fieldInfo{}
+	s.eachFieldValue(func(field, value string) {
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, fieldInfo{Name: field})
+		}
+	})
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// values suggests values for a field, based on its type and on the values
+// used with it in the open documents (most common first).
+func (s *server) values(field string) []string {
+	vals := []string{}
+	for _, f := range s.fields {
+		if f.Name != field {
+			continue
+		}
+		switch f.Type {
+		case "boolean":
+			vals = append(vals, "true", "false")
+		case "datetime":
+			today := time.Now().Format("2006-01-02")
+			vals = append(vals, "["+today+" TO "+today+"]", ">="+today, "<"+today)
+		}
+	}
+
+	counts := map[string]int{}
+	s.eachFieldValue(func(f, v string) {
+		if f == field {
+			counts[v]++
+		}
+	})
+	used := make([]string, 0, len(counts))
+	for v := range counts {
+		used = append(used, v)
+	}
+	sort.Slice(used, func(i, j int) bool {
+		if counts[used[i]] != counts[used[j]] {
+			return counts[used[i]] > counts[used[j]]
+		}
+		return used[i] < used[j]
+	})
+	return append(vals, used...)
+}
+
+// eachFieldValue calls fn for every field-scoped term and phrase in the open
+// documents. Phrases are passed with their quotes.
+func (s *server) eachFieldValue(fn func(field, value string)) {
+	for _, doc := range s.docs {
+		for _, q := range doc.queries {
+			tree, err := s.parser.ParseTree(q.text)
+			if err != nil {
+				continue
+			}
+			walk(tree, func(n qs.Node) {
+				f, ok := n.(*qs.FieldExpr)
+				if !ok {
+					return
+				}
+				walk(f.X, func(n qs.Node) {
+					switch n := n.(type) {
+					case *qs.Term:
+						fn(f.Field, n.Text)
+					case *qs.Phrase:
+						fn(f.Field, q.text[n.Pos():n.End()])
+					}
+				})
+				if _, ok := f.X.(*qs.RangeExpr); ok {
+					fn(f.Field, q.text[f.X.Pos():f.X.End()])
+				}
+			})
+		}
+	}
+}
+
+func (s *server) hover(params textDocumentPositionParams) (*hover, error) {
+	doc, err := s.doc(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	offset := doc.offset(params.Position)
+	q, ok := doc.queryAt(offset)
+	if !ok {
+		return nil, nil
+	}
+	tree, err := s.parser.ParseTree(q.text)
+	if err != nil {
+		return nil, nil // already reported as a diagnostic
+	}
+
+	path := pathTo(tree, offset-q.offset)
+	if len(path) < 2 {
+		return nil, nil // not over any clause
+	}
+	target := path[len(path)-1]
+
+	// compile the clause with any field from the enclosing scope
+	n := target
+	for i := len(path) - 2; i >= 0; i-- {
+		if f, ok := path[i].(*qs.FieldExpr); ok {
+			n = &qs.FieldExpr{Field: f.Field, X: n}
+			break
+		}
+	}
+	var value string
+	compiled, err := s.parser.Compile(n)
+	if err != nil {
+		msg := err.Error()
+		if pe, ok := err.(qs.ParseError); ok {
+			msg = pe.Msg
+		}
+		value = fmt.Sprintf("```qs\n%s\n```\n%s", qs.Format(target), msg)
+	} else {
+		js, err := json.MarshalIndent(compiled, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		value = fmt.Sprintf("```qs\n%s\n```\n```json\n%s\n```", qs.Format(target), js)
+	}
+	rng := doc.span(q.offset+target.Pos(), q.offset+target.End())
+	return &hover{Contents: markupContent{Kind: "markdown", Value: value}, Range: &rng}, nil
+}
+
+func (s *server) formatting(params documentFormattingParams) ([]textEdit, error) {
+	doc, err := s.doc(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	edits := []textEdit{}
+	for _, q := range doc.queries {
+		tree, err := s.parser.ParseTree(q.text)
+		if err != nil {
+			continue // leave broken queries alone
+		}
+		indent := q.text[:len(q.text)-len(strings.TrimLeft(q.text, " \t"))]
+		formatted := indent + qs.Format(tree)
+		if formatted != q.text {
+			edits = append(edits, textEdit{Range: doc.span(q.offset, q.end()), NewText: formatted})
+		}
+	}
+	return edits, nil
+}
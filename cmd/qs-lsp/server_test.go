@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFindQueries(t *testing.T) {
+	doc := newDocument("file:///saved.qs", "", "# citrus\ntags:citrus\n\n  lemon OR lime\r\n")
+	got := []string{}
+	for _, q := range doc.queries {
+		got = append(got, doc.text[q.offset:q.end()])
+	}
+	expected := []string{"tags:citrus", "  lemon OR lime"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	doc = newDocument("file:///notes.md", "markdown", "some text\n```qs\nfoo bar\n```\nnot:a query\n~~~ qs\nbaz\n~~~\n")
+	got = []string{}
+	for _, q := range doc.queries {
+		got = append(got, q.text)
+	}
+	expected = []string{"foo bar", "baz"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	doc := newDocument("file:///x.qs", "", "a\n𝄞é:b c\n")
+	for _, dat := range []struct {
+		offset int
+		pos    position
+	}{
+		{0, position{0, 0}},
+		{2, position{1, 0}},
+		{6, position{1, 2}}, // after the 4-byte clef, which is 2 UTF-16 units
+		{8, position{1, 3}},
+		{13, position{2, 0}},
+	} {
+		if got := doc.position(dat.offset); got != dat.pos {
+			t.Errorf("offset %d: expected %v, got %v", dat.offset, dat.pos, got)
+		}
+		if got := doc.offset(dat.pos); got != dat.offset {
+			t.Errorf("%v: expected offset %d, got %d", dat.pos, dat.offset, got)
+		}
+	}
+}
+
+func TestFieldInScope(t *testing.T) {
+	for _, dat := range []struct {
+		input string
+		field string
+	}{
+		{`tags:`, "tags"},
+		{`foo tags:(lemon `, "tags"},
+		{`tags:(lemon) `, ""},
+		{`foo `, ""},
+	} {
+		field, _ := fieldInScope(dat.input)
+		if field != dat.field {
+			t.Errorf("`%s`: expected '%s', got '%s'", dat.input, dat.field, field)
+		}
+	}
+}
+
+// session runs the server over a scripted set of messages, returning
+// everything it sends back.
+func session(t *testing.T, srv *server, msgs ...string) []map[string]interface{} {
+	var in bytes.Buffer
+	for _, msg := range msgs {
+		writeMessage(&in, json.RawMessage(msg))
+	}
+	var out bytes.Buffer
+	if err := srv.serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := []map[string]interface{}{}
+	r := bufio.NewReader(&out)
+	for {
+		hdr, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			break
+		}
+		n, _ := strconv.Atoi(hdr.Get("Content-Length"))
+		body := make([]byte, n)
+		io.ReadFull(r, body)
+		m := map[string]interface{}{}
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, m)
+	}
+	return replies
+}
+
+func TestServer(t *testing.T) {
+	srv := newServer()
+	srv.fields = []fieldInfo{{Name: "tags", Type: "text"}, {Name: "published", Type: "boolean"}}
+	replies := session(t, srv,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///a.qs","languageId":"qs","version":1,"text":"tags:citrus   -colour:orange\nfoo:(bar\n"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///a.qs"},"position":{"line":0,"character":6}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///a.qs"},"position":{"line":0,"character":2}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/formatting","params":{"textDocument":{"uri":"file:///a.qs"},"options":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	if len(replies) != 6 {
+		t.Fatalf("expected 6 messages, got %d: %v", len(replies), replies)
+	}
+
+	// diagnostics
+	diags := replies[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	if len(diags) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diags)
+	}
+	if msg := diags[0].(map[string]interface{})["message"]; msg != "unknown field 'colour'" {
+		t.Errorf("unexpected diagnostic: %v", diags[0])
+	}
+	if msg := diags[1].(map[string]interface{})["message"]; msg != "missing )" {
+		t.Errorf("unexpected diagnostic: %v", diags[1])
+	}
+
+	// hover shows the term compiled with its field
+	contents := replies[2]["result"].(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	if !strings.Contains(contents, `"match_phrase": "citrus"`) || !strings.Contains(contents, `"field": "tags"`) {
+		t.Errorf("unexpected hover: %s", contents)
+	}
+
+	// completion offers fields
+	items := replies[3]["result"].([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["label"] != "tags:" {
+		t.Errorf("unexpected completions: %v", items)
+	}
+
+	// formatting tidies the first query, and leaves the broken one alone
+	edits := replies[4]["result"].([]interface{})
+	if len(edits) != 1 || edits[0].(map[string]interface{})["newText"] != "tags:citrus -colour:orange" {
+		t.Errorf("unexpected edits: %v", edits)
+	}
+}
+
+func TestCompletionValues(t *testing.T) {
+	srv := newServer()
+	srv.fields = []fieldInfo{{Name: "published", Type: "boolean"}, {Name: "tags", Type: "text"}}
+	srv.docs["file:///b.qs"] = newDocument("file:///b.qs", "qs", "tags:citrus tags:cider\npublished:t tags:(citrus \n")
+
+	items, err := srv.completion(textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{"file:///b.qs"},
+		Position:     position{1, 11},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Label != "true" {
+		t.Errorf("unexpected completions: %v", items)
+	}
+
+	items, err = srv.completion(textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{"file:///b.qs"},
+		Position:     position{1, 25},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, item := range items {
+		got = append(got, item.Label)
+	}
+	if !reflect.DeepEqual(got, []string{"cider", "citrus"}) {
+		t.Errorf("unexpected completions: %v", got)
+	}
+}
+
+func TestLoadFields(t *testing.T) {
+	f, err := ioutil.TempFile("", "mapping*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"mapping": {"default_mapping": {"enabled": true, "properties": {
+		"title": {"enabled": true, "fields": [{"type": "text", "index": true}]},
+		"meta": {"enabled": true, "properties": {
+			"pub": {"enabled": true, "fields": [{"name": "published", "type": "datetime", "index": true}]}
+		}}
+	}}}}`)
+	f.Close()
+
+	fields, err := loadFields(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fieldInfo{{"meta.published", "datetime"}, {"title", "text"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/bcampbell/qs"
+)
+
+// walk calls fn for n and all its descendants, depth first
+func walk(n qs.Node, fn func(qs.Node)) {
+	qs.Inspect(n, func(n qs.Node) bool {
+		if n != nil {
+			fn(n)
+		}
+		return true
+	})
+}
+
+// pathTo returns the chain of nodes from the root down to the innermost
+// node containing offset.
+func pathTo(root qs.Node, offset int) []qs.Node {
+	path := []qs.Node{}
+	qs.Inspect(root, func(n qs.Node) bool {
+		if n == nil {
+			return false
+		}
+		if n == root || (n.Pos() <= offset && offset < n.End()) {
+			path = append(path, n)
+			return true
+		}
+		return false
+	})
+	return path
+}
@@ -0,0 +1,20 @@
+// qsvet - checks constant query strings passed to qs.Parse.
+//
+// Run it via go vet:
+//
+//   $ go vet -vettool=$(which qsvet) ./...
+//
+// To also check fields against an allowlist:
+//
+//   $ go vet -vettool=$(which qsvet) -qsparse.fields=title,tags,meta.* ./...
+//
+package main
+
+import (
+	"github.com/bcampbell/qs/qsvet"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(qsvet.Analyzer)
+}
@@ -0,0 +1,309 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/search/query"
+	"strings"
+)
+
+// context is used to hold settings active within a given scope during
+// compilation
+type context struct {
+	// field is the name of the field currently in scope (or "")
+	field    string
+	fieldPos int
+	// srcmap, if set, records where each query came from
+	srcmap *SourceMap
+	// macro is the outermost macro reference being expanded (if any).
+	// Positions inside it are relative to the macro, so its span is
+	// recorded instead.
+	macro *MacroExpr
+}
+
+// Compile turns a syntax tree (as returned by ParseTree) into a bleve Query,
+// using the Parser's settings.
+//
+// Returned errors are type ParseError, with positions taken from the nodes
+// of the tree, or MacroError for problems within expanded macros.
+func (p *Parser) Compile(n Node) (query.Query, error) {
+	return p.compile(n, context{})
+}
+
+func (p *Parser) compile(n Node, ctx context) (query.Query, error) {
+	q, err := p.compileNode(n, ctx)
+	if err == nil && ctx.srcmap != nil {
+		if ctx.macro != nil {
+			ctx.srcmap.record(q, ctx.macro)
+		} else {
+			ctx.srcmap.record(q, n)
+		}
+	}
+	return q, err
+}
+
+func (p *Parser) compileNode(n Node, ctx context) (query.Query, error) {
+	switch n := n.(type) {
+	case *List:
+		return p.compileList(n, ctx)
+	case *BoolExpr:
+		return p.compileBool(n, ctx)
+	case *NotExpr:
+		return p.compileNot(n, ctx)
+	case *PrefixExpr:
+		// outside a list, '+' means nothing and '-' means NOT
+		q, err := p.compile(n.X, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == '-' {
+			q = mustNot(q)
+		}
+		return q, nil
+	case *FieldExpr:
+		if ctx.field != "" {
+			return nil, ParseError{n.Pos(), fmt.Sprintf("'%s:' clashes with '%s:'", n.Field, ctx.field)}
+		}
+		ctx.field = n.Field
+		ctx.fieldPos = n.Pos()
+		return p.compile(n.X, ctx)
+	case *BoostExpr:
+		return p.compileBoost(n, ctx)
+	case *Group:
+		return p.compileList(n.X, ctx)
+	case *TermsLookup:
+		return p.compileLookup(n, ctx)
+	case *MacroExpr:
+		if ctx.macro == nil {
+			ctx.macro = n
+		}
+		q, err := p.compileList(n.X, ctx)
+		if err != nil {
+			// positions within the expansion are relative to the macro
+			return nil, MacroError{n.Pos(), n.Name, err}
+		}
+		return q, nil
+	case *Term:
+		return p.compileTerm(n, ctx)
+	case *Phrase:
+		q := bleve.NewMatchPhraseQuery(n.Text)
+		if ctx.field != "" {
+			q.SetField(ctx.field)
+		}
+		return q, nil
+	case *RangeExpr:
+		return p.compileRange(n, ctx)
+	}
+	return nil, ParseError{n.Pos(), fmt.Sprintf("unsupported node %T", n)}
+}
+
+// mustNot wraps a query in a BooleanQuery to negate it
+func mustNot(q query.Query) query.Query {
+	b := bleve.NewBooleanQuery()
+	b.AddMustNot(q)
+	return b
+}
+
+// compileList handles a sequence of clauses, combined according to their
+// prefixes and the default operator.
+func (p *Parser) compileList(l *List, ctx context) (query.Query, error) {
+	if p.DefaultOp == OR {
+		if q := p.termSet(l.Clauses, ctx); q != nil {
+			return q, nil
+		}
+	}
+
+	// size the default bucket up front, as it usually gets everything
+	var must, mustNot, should []query.Query
+	if p.DefaultOp == AND {
+		must = make([]query.Query, 0, len(l.Clauses))
+	} else {
+		should = make([]query.Query, 0, len(l.Clauses))
+	}
+
+	for _, clause := range l.Clauses {
+		prefix := rune(0)
+		if pe, ok := clause.(*PrefixExpr); ok {
+			prefix = pe.Op
+			clause = pe.X
+		}
+		q, err := p.compile(clause, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		switch prefix {
+		case '+':
+			must = append(must, q)
+		case '-':
+			mustNot = append(mustNot, q)
+		default:
+			if p.DefaultOp == AND {
+				must = append(must, q)
+			} else { // OR
+				should = append(should, q)
+			}
+		}
+	}
+
+	// some obvious shortcuts
+	total := len(must) + len(mustNot) + len(should)
+	if total == 0 {
+		return bleve.NewMatchNoneQuery(), nil
+	}
+	if total == 1 && len(must) == 1 {
+		return must[0], nil
+	}
+	if total == 1 && len(should) == 1 {
+		return should[0], nil
+	}
+
+	// no shortcuts - go with the full-fat version
+	q := bleve.NewBooleanQuery()
+	if len(must) > 0 {
+		q.AddMust(must...)
+	}
+	if len(should) > 0 {
+		q.AddShould(should...)
+	}
+	if len(mustNot) > 0 {
+		q.AddMustNot(mustNot...)
+	}
+	return q, nil
+}
+
+// compileBool handles OR and AND expressions
+func (p *Parser) compileBool(b *BoolExpr, ctx context) (query.Query, error) {
+	if b.Op == OR {
+		if q := p.termSet(b.Operands, ctx); q != nil {
+			return q, nil
+		}
+	}
+	queries := make([]query.Query, len(b.Operands))
+	for i, operand := range b.Operands {
+		// KLUDGINESS - prefixes on terms in OR/AND expressions
+		// we'll ignore "+" and treat "-" as NOT
+		// eg:
+		// `+alice OR -bob OR chuck`  => `alice OR (NOT bob) OR chuck`
+		// `+alice AND -bob AND chuck`  => `alice AND (NOT bob) AND chuck`
+		q, err := p.compile(operand, ctx)
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = q
+	}
+	if b.Op == AND {
+		return bleve.NewConjunctionQuery(queries...), nil
+	}
+	return bleve.NewDisjunctionQuery(queries...), nil
+}
+
+// compileNot handles NOT expressions
+func (p *Parser) compileNot(n *NotExpr, ctx context) (query.Query, error) {
+	x := n.X
+	prefix := rune(0)
+	if pe, ok := x.(*PrefixExpr); ok {
+		prefix = pe.Op
+		x = pe.X
+	}
+	q, err := p.compile(x, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// KLUDGINESS - prefixes on terms in NOT expressions:
+	// `NOT -bob`  => `bob`
+	// `NOT +bob`  => `NOT bob`
+	if prefix != '-' {
+		q = mustNot(q)
+	}
+	return q, nil
+}
+
+func (p *Parser) compileBoost(b *BoostExpr, ctx context) (query.Query, error) {
+	q, err := p.compile(b.X, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if b.Boost > 0 {
+		if boostable, ok := q.(query.BoostableQuery); ok {
+			boostable.SetBoost(b.Boost)
+		} else {
+			return nil, ParseError{b.BoostPos, "can't specify a boost value here"}
+		}
+	}
+	return q, nil
+}
+
+func (p *Parser) compileTerm(t *Term, ctx context) (query.Query, error) {
+	var q query.Query
+	if strings.ContainsAny(t.Text, "*?") {
+		q = bleve.NewWildcardQuery(t.Text)
+	} else if t.Fuzzy {
+		fuzz := bleve.NewFuzzyQuery(t.Text)
+		fuzz.SetFuzziness(t.Fuzziness)
+		q = fuzz
+	} else {
+		q = bleve.NewMatchPhraseQuery(t.Text)
+	}
+	return setField(q, ctx)
+}
+
+// termSet compiles a disjunction of plain values on a keyword field into a
+// single TermSetQuery. On a keyword field each value is indexed as a single
+// term, so this matches the same documents as a disjunction of
+// MatchPhraseQuery objects but is much cheaper for big lists of IDs.
+// Returns nil if the nodes don't qualify.
+func (p *Parser) termSet(nodes []Node, ctx context) *TermSetQuery {
+	if len(nodes) < 2 || ctx.field == "" || !p.isKeyword(ctx.field) {
+		return nil
+	}
+	terms := make([]string, len(nodes))
+	for i, n := range nodes {
+		switch n := n.(type) {
+		case *Term:
+			if n.Fuzzy || containsWildcard(n.Text) {
+				return nil
+			}
+			terms[i] = n.Text
+		case *Phrase:
+			if n.Text == "" {
+				return nil
+			}
+			terms[i] = n.Text
+		default:
+			return nil
+		}
+	}
+	q := NewTermSetQuery(terms)
+	q.SetField(ctx.field)
+	return q
+}
+
+// isKeyword returns true if the mapping says the field isn't broken up
+// into multiple terms when indexed.
+func (p *Parser) isKeyword(field string) bool {
+	return p.Mapping != nil && p.Mapping.AnalyzerNameForPath(field) == keyword.Name
+}
+
+func (p *Parser) compileRange(r *RangeExpr, ctx context) (query.Query, error) {
+	rp := newRangeParams(r.Min, r.Max, r.MinInclusive, r.MaxInclusive, p.Loc)
+	q, err := rp.generate()
+	if err != nil {
+		return nil, ParseError{r.Pos(), err.Error()}
+	}
+	return setField(q, ctx)
+}
+
+// setField applies the field in scope (if any) to a query
+func setField(q query.Query, ctx context) (query.Query, error) {
+	if ctx.field != "" {
+		if fieldable, ok := q.(query.FieldableQuery); ok {
+			fieldable.SetField(ctx.field)
+		} else {
+			return nil, ParseError{ctx.fieldPos, "unexpected field"}
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,439 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/numeric"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/blevesearch/bleve/search/searcher"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cost is an estimate of the work involved in running a query, and the
+// size of the result, as returned by EstimateCost.
+type Cost struct {
+	// Terms is the number of index terms the search will read
+	Terms int
+	// Postings is the total number of documents listed under those terms,
+	// a rough measure of the work involved
+	Postings uint64
+	// Hits is an upper estimate of the number of documents matched
+	Hits uint64
+	// Expansions lists the parts of the query which cover several terms
+	// (wildcards, fuzzy terms and ranges), in order
+	Expansions []Expansion
+}
+
+// Expansion is a part of a query which covers several terms in the index.
+type Expansion struct {
+	// Span locates it in the query string, and Text is the source
+	Span Span
+	Text string
+	// Terms is the number of terms (or for ranges, distinct values) it
+	// covers, and Postings the number of documents listed under them
+	Terms    int
+	Postings uint64
+	isRange  bool
+}
+
+// String describes the expansion, eg "`a*` expands to 48,000 terms", noting
+// if that's more than bleve's searcher.DisjunctionMaxClauseCount allows.
+func (e Expansion) String() string {
+	verb, unit := "expands to", "term"
+	if e.isRange {
+		verb, unit = "covers", "value"
+	}
+	if e.Terms != 1 {
+		unit += "s"
+	}
+	s := fmt.Sprintf("`%s` %s %s %s", e.Text, verb, formatCount(uint64(e.Terms)), unit)
+	if !e.isRange && searcher.DisjunctionMaxClauseCount > 0 && e.Terms > searcher.DisjunctionMaxClauseCount {
+		s += fmt.Sprintf(" (over the limit of %s)", formatCount(uint64(searcher.DisjunctionMaxClauseCount)))
+	}
+	return s
+}
+
+// EstimateCost estimates the cost of running a query against an index,
+// using the default Parser. See Parser.EstimateCost.
+func EstimateCost(idx bleve.Index, q string) (*Cost, error) {
+	p := Parser{DefaultOp: OR}
+	return p.EstimateCost(idx, q)
+}
+
+// EstimateCost estimates the cost of running a query against an index,
+// without running it, so heavy queries can be turned away or queued. It
+// looks up the document frequency of each term, counts the terms which
+// wildcards and fuzzy terms expand to, and the distinct values in each
+// range.
+//
+// The hit count is an upper bound worked out from the document
+// frequencies: the smallest of the parts for AND, the sum for OR, and
+// exclusions are ignored.
+//
+// Expanding wildcards and counting range values reads the index's term
+// dictionaries, which is much cheaper than a search but not free.
+//
+// Returned errors are from parsing q or reading the index.
+func (p *Parser) EstimateCost(idx bleve.Index, q string) (*Cost, error) {
+	compiled, sm, err := p.ParseWithSourceMap(q)
+	if err != nil {
+		return nil, err
+	}
+	i, _, err := idx.Advanced()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := i.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	docs, err := reader.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	m := p.Mapping
+	if m == nil {
+		m = idx.Mapping()
+	}
+	e := estimator{reader: reader, m: m, sm: sm, docs: docs, cost: &Cost{}}
+	hits, err := e.estimate(compiled)
+	if err != nil {
+		return nil, err
+	}
+	e.cost.Hits = hits
+	return e.cost, nil
+}
+
+type estimator struct {
+	reader index.IndexReader
+	m      mapping.IndexMapping
+	sm     *SourceMap
+	docs   uint64
+	cost   *Cost
+}
+
+// estimate adds the cost of q, and returns an upper bound on its hits
+func (e *estimator) estimate(q query.Query) (uint64, error) {
+	switch q := q.(type) {
+	case *query.MatchAllQuery:
+		return e.docs, nil
+	case *query.MatchNoneQuery:
+		return 0, nil
+	case *query.ConjunctionQuery:
+		return e.all(q.Conjuncts)
+	case *query.DisjunctionQuery:
+		return e.any(q.Disjuncts)
+	case *query.BooleanQuery:
+		hits := e.docs
+		if q.Must != nil {
+			n, err := e.estimate(q.Must)
+			if err != nil {
+				return 0, err
+			}
+			hits = n
+		}
+		if q.Should != nil {
+			n, err := e.estimate(q.Should)
+			if err != nil {
+				return 0, err
+			}
+			// shoulds are only required without musts
+			if q.Must == nil {
+				hits = n
+			}
+		}
+		if q.MustNot != nil {
+			if _, err := e.estimate(q.MustNot); err != nil {
+				return 0, err
+			}
+		}
+		return hits, nil
+	case *query.MatchPhraseQuery:
+		field := e.field(q.FieldVal)
+		var terms []string
+		if analyzer := e.m.AnalyzerNamed(e.m.AnalyzerNameForPath(field)); analyzer != nil {
+			for _, token := range analyzer.Analyze([]byte(q.MatchPhrase)) {
+				terms = append(terms, string(token.Term))
+			}
+		} else {
+			terms = strings.Fields(q.MatchPhrase)
+		}
+		if len(terms) == 0 {
+			return 0, nil
+		}
+		// every term has to be there
+		hits := e.docs
+		for _, term := range terms {
+			n, err := e.term(field, term)
+			if err != nil {
+				return 0, err
+			}
+			if n < hits {
+				hits = n
+			}
+		}
+		return hits, nil
+	case *TermSetQuery:
+		field := e.field(q.FieldVal)
+		var hits uint64
+		for _, term := range q.Terms {
+			n, err := e.term(field, term)
+			if err != nil {
+				return 0, err
+			}
+			hits += n
+		}
+		return e.capped(hits), nil
+	case *query.WildcardQuery:
+		field := e.field(q.FieldVal)
+		return e.expand(q, func() (index.FieldDict, error) {
+			if r, ok := e.reader.(index.IndexReaderRegexp); ok {
+				return r.FieldDictRegexp(field, wildcardRegexp(q.Wildcard))
+			}
+			return e.matchingRegexp(field, "^"+wildcardRegexp(q.Wildcard)+"$")
+		})
+	case *query.RegexpQuery:
+		field := e.field(q.FieldVal)
+		return e.expand(q, func() (index.FieldDict, error) {
+			if r, ok := e.reader.(index.IndexReaderRegexp); ok {
+				return r.FieldDictRegexp(field, q.Regexp)
+			}
+			return e.matchingRegexp(field, "^(?:"+q.Regexp+")$")
+		})
+	case *query.FuzzyQuery:
+		field := e.field(q.FieldVal)
+		return e.expand(q, func() (index.FieldDict, error) {
+			prefix := q.Term
+			if runes := []rune(q.Term); q.Prefix < len(runes) {
+				prefix = string(runes[:q.Prefix])
+			}
+			if r, ok := e.reader.(index.IndexReaderFuzzy); ok {
+				return r.FieldDictFuzzy(field, q.Term, q.Fuzziness, prefix)
+			}
+			return e.matching(field, func(term string) bool {
+				_, over := search.LevenshteinDistanceMax(q.Term, term, q.Fuzziness)
+				return !over && strings.HasPrefix(term, prefix)
+			})
+		})
+	case *query.NumericRangeQuery, *query.DateRangeQuery, *query.TermRangeQuery:
+		return e.rangeValues(q)
+	}
+	// something we don't know about - assume the worst
+	return e.docs, nil
+}
+
+// all adds the cost of queries which must all match
+func (e *estimator) all(qs []query.Query) (uint64, error) {
+	hits := e.docs
+	for _, q := range qs {
+		n, err := e.estimate(q)
+		if err != nil {
+			return 0, err
+		}
+		if n < hits {
+			hits = n
+		}
+	}
+	return hits, nil
+}
+
+// any adds the cost of alternatives
+func (e *estimator) any(qs []query.Query) (uint64, error) {
+	var hits uint64
+	for _, q := range qs {
+		n, err := e.estimate(q)
+		if err != nil {
+			return 0, err
+		}
+		hits += n
+	}
+	return e.capped(hits), nil
+}
+
+func (e *estimator) capped(hits uint64) uint64 {
+	if hits > e.docs {
+		return e.docs
+	}
+	return hits
+}
+
+func (e *estimator) field(field string) string {
+	if field == "" {
+		return e.m.DefaultSearchField()
+	}
+	return field
+}
+
+// term adds the cost of reading a single term, and returns its document
+// frequency
+func (e *estimator) term(field, term string) (uint64, error) {
+	r, err := e.reader.TermFieldReader([]byte(term), field, false, false, false)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n := r.Count()
+	e.cost.Terms++
+	e.cost.Postings += n
+	return n, nil
+}
+
+// expand adds the cost of the terms in a dictionary, and records it as an
+// Expansion of q
+func (e *estimator) expand(q query.Query, open func() (index.FieldDict, error)) (uint64, error) {
+	dict, err := open()
+	if err != nil {
+		return 0, err
+	}
+	defer dict.Close()
+	ex := Expansion{Text: e.sm.Text(q)}
+	ex.Span, _ = e.sm.Span(q)
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return 0, err
+		}
+		if entry == nil {
+			break
+		}
+		ex.Terms++
+		ex.Postings += entry.Count
+	}
+	return e.addExpansion(ex), nil
+}
+
+func (e *estimator) addExpansion(ex Expansion) uint64 {
+	e.cost.Terms += ex.Terms
+	e.cost.Postings += ex.Postings
+	e.cost.Expansions = append(e.cost.Expansions, ex)
+	return e.capped(ex.Postings)
+}
+
+// rangeValues adds the cost of a range, counting the distinct values in
+// it. (bleve searches numeric and date ranges using coarser terms where it
+// can, so the terms actually read will be fewer.)
+func (e *estimator) rangeValues(q query.Query) (uint64, error) {
+	// toInterval doesn't take boosted ranges, and the boost doesn't matter
+	// here
+	q2 := copyQuery(q)
+	clearBoosts(reflect.ValueOf(q2))
+	iv, ok := toInterval(q2)
+	if !ok {
+		return e.docs, nil
+	}
+	field := e.field(iv.field)
+	dict, err := e.reader.FieldDict(field)
+	if err != nil {
+		return 0, err
+	}
+	defer dict.Close()
+	ex := Expansion{Text: e.sm.Text(q), isRange: true}
+	ex.Span, _ = e.sm.Span(q)
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return 0, err
+		}
+		if entry == nil {
+			break
+		}
+		v, ok := decodeTerm(iv.kind, entry.Term)
+		if ok && iv.holds(v) {
+			ex.Terms++
+			ex.Postings += entry.Count
+		}
+	}
+	return e.addExpansion(ex), nil
+}
+
+// decodeTerm decodes a term from the index into a value for a range of the
+// given kind. Numeric and date terms are prefix coded, and only the full
+// precision ones count.
+func decodeTerm(kind int, term string) (interface{}, bool) {
+	if kind == termInterval {
+		return term, true
+	}
+	pc := numeric.PrefixCoded(term)
+	if shift, err := pc.Shift(); err != nil || shift != 0 {
+		return nil, false
+	}
+	i, err := pc.Int64()
+	if err != nil {
+		return nil, false
+	}
+	if kind == dateInterval {
+		return time.Unix(0, i).UTC(), true
+	}
+	return numeric.Int64ToFloat64(i), true
+}
+
+// holds returns true if v is within the interval
+func (iv interval) holds(v interface{}) bool {
+	if iv.lo != nil {
+		c := compareValues(v, iv.lo.v)
+		if c < 0 || (c == 0 && !iv.lo.inc) {
+			return false
+		}
+	}
+	if iv.hi != nil {
+		c := compareValues(v, iv.hi.v)
+		if c > 0 || (c == 0 && !iv.hi.inc) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchingRegexp returns the terms of a field which match a regular
+// expression, for indexes which can't do it themselves
+func (e *estimator) matchingRegexp(field, expr string) (index.FieldDict, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return e.matching(field, re.MatchString)
+}
+
+// matching returns the terms of a field accepted by match
+func (e *estimator) matching(field string, match func(string) bool) (index.FieldDict, error) {
+	dict, err := e.reader.FieldDict(field)
+	if err != nil {
+		return nil, err
+	}
+	return &filteredDict{dict, match}, nil
+}
+
+type filteredDict struct {
+	index.FieldDict
+	match func(string) bool
+}
+
+func (d *filteredDict) Next() (*index.DictEntry, error) {
+	for {
+		entry, err := d.FieldDict.Next()
+		if entry == nil || err != nil {
+			return entry, err
+		}
+		if d.match(entry.Term) {
+			return entry, nil
+		}
+	}
+}
+
+// formatCount formats a number with thousands separators, eg 48,000
+func formatCount(n uint64) string {
+	s := strconv.FormatUint(n, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
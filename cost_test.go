@@ -0,0 +1,83 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/searcher"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	for i := 0; i < 100; i++ {
+		doc := map[string]interface{}{
+			"title": fmt.Sprintf("lemon word%d", i),
+			"price": i % 10,
+			"date":  fmt.Sprintf("2015-01-%02dT00:00:00Z", 1+i%20),
+		}
+		if i%4 == 0 {
+			doc["tags"] = "citrus"
+		}
+		if err := idx.Index(fmt.Sprintf("doc%d", i), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q          string
+		terms      int
+		postings   uint64
+		hits       uint64
+		expansions []string
+	}{
+		{`lemon`, 1, 100, 100, nil},
+		{`tags:citrus`, 1, 25, 25, nil},
+		{`tags:citrus AND lemon`, 2, 125, 25, nil},
+		{`tags:citrus OR title:word1`, 2, 26, 26, nil},
+		{`tags:citrus -lemon`, 2, 125, 25, nil},
+		{`title:"lemon word7"`, 2, 101, 1, nil},
+		{`title:word1*`, 11, 11, 11, []string{"`title:word1*` expands to 11 terms"}},
+		{`title:wurd1~1`, 1, 1, 1, []string{"`title:wurd1~1` expands to 1 term"}},
+		{`price:[2 TO 4]`, 3, 30, 30, []string{"`price:[2 TO 4]` covers 3 values"}},
+		{`date:>=2015-01-16`, 5, 25, 25, []string{"`date:>=2015-01-16` covers 5 values"}},
+		{`nothing`, 1, 0, 0, nil},
+	}
+	for _, test := range tests {
+		c, err := EstimateCost(idx, test.q)
+		if err != nil {
+			t.Errorf("`%s`: %s", test.q, err)
+			continue
+		}
+		var expansions []string
+		for _, ex := range c.Expansions {
+			expansions = append(expansions, ex.String())
+		}
+		if c.Terms != test.terms || c.Postings != test.postings || c.Hits != test.hits || fmt.Sprint(expansions) != fmt.Sprint(test.expansions) {
+			t.Errorf("`%s`: expected %d terms, %d postings, %d hits %q, got %d, %d, %d %q", test.q,
+				test.terms, test.postings, test.hits, test.expansions, c.Terms, c.Postings, c.Hits, expansions)
+		}
+	}
+
+	// bleve can be set up to refuse big expansions
+	defer func(n int) { searcher.DisjunctionMaxClauseCount = n }(searcher.DisjunctionMaxClauseCount)
+	searcher.DisjunctionMaxClauseCount = 10
+	c, err := EstimateCost(idx, `word*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Expansions[0].String(); got != "`word*` expands to 100 terms (over the limit of 10)" {
+		t.Errorf("unexpected expansion %q", got)
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, expect := range map[uint64]string{0: "0", 999: "999", 1000: "1,000", 48000: "48,000", 1234567: "1,234,567"} {
+		if got := formatCount(n); got != expect {
+			t.Errorf("%d: expected %s, got %s", n, expect, got)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"strings"
+)
+
+// Diagnosis explains why a query finds nothing.
+type Diagnosis struct {
+	// Total is the number of documents the whole query matches
+	Total uint64
+	// Problems lists the parts of the query which leave the result
+	// empty (none if Total isn't 0)
+	Problems []Problem
+}
+
+// Problem is one reason a query matches nothing.
+type Problem struct {
+	// Spans locates the parts of the query string involved
+	Spans []Span
+	// Msg describes the problem, eg "`date:[2030-01-01 TO ]` matches 0
+	// documents"
+	Msg string
+}
+
+func (p Problem) String() string { return p.Msg }
+
+func (d *Diagnosis) String() string {
+	if len(d.Problems) == 0 {
+		return fmt.Sprintf("matches %d documents", d.Total)
+	}
+	msgs := make([]string, len(d.Problems))
+	for i, p := range d.Problems {
+		msgs[i] = p.Msg
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Diagnose runs a query against an index, and if nothing matches, works out
+// why, using the default Parser. See Parser.Diagnose.
+func Diagnose(idx bleve.Index, q string) (*Diagnosis, error) {
+	p := Parser{DefaultOp: OR}
+	return p.Diagnose(idx, q)
+}
+
+// Diagnose runs a query against an index, and if nothing matches, works out
+// which clause, or combination of clauses, empties the result, by counting
+// the documents each part matches on its own. For example:
+//
+//   the field 'colour' has no indexed terms
+//   `date:[2030-01-01 TO ]` matches 0 documents
+//   no document matches both `tags:citrus` and `tags:paint`
+//   excluding `lemon` removes all 3 documents matched by the rest of the query
+//
+// Each Problem gives the spans of the parts of q involved. This runs a
+// search for every part of the query it looks at, so it's meant for
+// support tools rather than every query.
+//
+// Returned errors are from parsing q or searching the index.
+func (p *Parser) Diagnose(idx bleve.Index, q string) (*Diagnosis, error) {
+	compiled, sm, err := p.ParseWithSourceMap(q)
+	if err != nil {
+		return nil, err
+	}
+	dg := diagnoser{idx: idx, sm: sm, seen: map[string]int{}}
+	total, err := dg.count(compiled)
+	if err != nil {
+		return nil, err
+	}
+	d := &Diagnosis{Total: total}
+	if total == 0 {
+		if err := dg.empty(compiled); err != nil {
+			return nil, err
+		}
+		d.Problems = dg.problems
+	}
+	return d, nil
+}
+
+type diagnoser struct {
+	idx      bleve.Index
+	sm       *SourceMap
+	problems []Problem
+	// seen maps messages to problems, so the same problem in several
+	// places (eg two clauses on a missing field) is only reported once
+	seen map[string]int
+}
+
+func (dg *diagnoser) report(msg string, qs ...query.Query) {
+	i, ok := dg.seen[msg]
+	if !ok {
+		i = len(dg.problems)
+		dg.seen[msg] = i
+		dg.problems = append(dg.problems, Problem{Msg: msg})
+	}
+	prob := &dg.problems[i]
+	for _, q := range qs {
+		span, ok := dg.sm.Span(q)
+		if ok && !hasSpan(prob.Spans, span) {
+			prob.Spans = append(prob.Spans, span)
+		}
+	}
+}
+
+func hasSpan(spans []Span, span Span) bool {
+	for _, s := range spans {
+		if s == span {
+			return true
+		}
+	}
+	return false
+}
+
+func (dg *diagnoser) count(q query.Query) (uint64, error) {
+	return countHits(dg.idx, q)
+}
+
+// countHits returns the number of documents in idx matching q
+func countHits(idx bleve.Index, q query.Query) (uint64, error) {
+	res, err := idx.Search(bleve.NewSearchRequestOptions(q, 0, 0, false))
+	if err != nil {
+		return 0, err
+	}
+	return res.Total, nil
+}
+
+// counts returns the number of documents each of qs matches
+func (dg *diagnoser) counts(qs []query.Query) ([]uint64, error) {
+	out := make([]uint64, len(qs))
+	for i, q := range qs {
+		n, err := dg.count(q)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+// text returns the source of q, quoted for use in a message
+func (dg *diagnoser) text(q query.Query) string {
+	if text := dg.sm.Text(q); text != "" {
+		return "`" + text + "`"
+	}
+	return "part of the query"
+}
+
+// empty finds out why q, which matches nothing, is empty
+func (dg *diagnoser) empty(q query.Query) error {
+	switch q := q.(type) {
+	case *query.MatchNoneQuery:
+		if dg.sm.Text(q) == "" {
+			dg.report("the query is empty", q)
+		} else {
+			dg.report(dg.text(q)+" can't match anything", q)
+		}
+		return nil
+	case *query.DisjunctionQuery:
+		if q.Min > 1 {
+			break
+		}
+		// every alternative is empty
+		for _, sub := range q.Disjuncts {
+			if err := dg.empty(sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *query.ConjunctionQuery:
+		return dg.conjunction(q.Conjuncts)
+	case *query.BooleanQuery:
+		return dg.boolean(q)
+	}
+	return dg.leaf(q)
+}
+
+// conjunction finds out why a set of queries have nothing in common
+func (dg *diagnoser) conjunction(qs []query.Query) error {
+	counts, err := dg.counts(qs)
+	if err != nil {
+		return err
+	}
+	found := false
+	for i, n := range counts {
+		if n == 0 {
+			found = true
+			if err := dg.empty(qs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if found {
+		return nil
+	}
+
+	// each matches something, so find a minimal set which doesn't
+	set := append([]query.Query(nil), qs...)
+	for i := 0; i < len(set) && len(set) > 2; {
+		rest := make([]query.Query, 0, len(set)-1)
+		rest = append(append(rest, set[:i]...), set[i+1:]...)
+		n, err := dg.count(query.NewConjunctionQuery(rest))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			set = rest
+		} else {
+			i++
+		}
+	}
+	texts := make([]string, len(set))
+	for i, q := range set {
+		texts[i] = dg.text(q)
+	}
+	if len(set) == 2 {
+		dg.report(fmt.Sprintf("no document matches both %s and %s", texts[0], texts[1]), set...)
+	} else {
+		dg.report("no document matches all of "+joinPhrases(texts, "and"), set...)
+	}
+	return nil
+}
+
+// boolean finds out why a BooleanQuery is empty: either what it requires
+// matches nothing, or the exclusions remove everything it does match
+func (dg *diagnoser) boolean(b *query.BooleanQuery) error {
+	var required query.Query
+	switch {
+	case b.Must != nil:
+		// shoulds are optional alongside musts
+		required = b.Must
+	case b.Should != nil:
+		required = b.Should
+	default:
+		required = bleve.NewMatchAllQuery()
+	}
+	n, err := dg.count(required)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return dg.empty(required)
+	}
+	if b.MustNot == nil {
+		// shouldn't happen, but just in case
+		return dg.leaf(b)
+	}
+
+	rest := "the rest of the query"
+	if b.Must == nil && b.Should == nil {
+		rest = "the index"
+	}
+	exclusions := []query.Query{b.MustNot}
+	if d, ok := b.MustNot.(*query.DisjunctionQuery); ok {
+		exclusions = d.Disjuncts
+	}
+	// look for a single exclusion which does it
+	for _, ex := range exclusions {
+		without := bleve.NewBooleanQuery()
+		without.AddMust(required)
+		without.AddMustNot(ex)
+		m, err := dg.count(without)
+		if err != nil {
+			return err
+		}
+		if m == 0 {
+			dg.report(fmt.Sprintf("excluding %s removes all %d documents matched by %s", dg.text(ex), n, rest), ex)
+			return nil
+		}
+	}
+	texts := make([]string, len(exclusions))
+	for i, ex := range exclusions {
+		texts[i] = dg.text(ex)
+	}
+	dg.report(fmt.Sprintf("excluding %s together removes all %d documents matched by %s", joinPhrases(texts, "and"), n, rest), exclusions...)
+	return nil
+}
+
+// leaf reports a query which matches nothing by itself, checking first
+// whether its field has anything in it
+func (dg *diagnoser) leaf(q query.Query) error {
+	if f, ok := q.(query.FieldableQuery); ok && f.Field() != "" {
+		empty, err := dg.emptyField(f.Field())
+		if err != nil {
+			return err
+		}
+		if empty {
+			dg.report(fmt.Sprintf("the field '%s' has no indexed terms", f.Field()), q)
+			return nil
+		}
+	}
+	dg.report(dg.text(q)+" matches 0 documents", q)
+	return nil
+}
+
+// emptyField returns true if the index has no terms for a field
+func (dg *diagnoser) emptyField(field string) (bool, error) {
+	dict, err := dg.idx.FieldDict(field)
+	if err != nil {
+		return false, err
+	}
+	defer dict.Close()
+	entry, err := dict.Next()
+	if err != nil {
+		return false, err
+	}
+	return entry == nil, nil
+}
@@ -0,0 +1,84 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve"
+	"reflect"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "lemon tart", "tags": "citrus", "date": "2015-03-01T00:00:00Z"},
+		"2": {"title": "lime pickle", "tags": "citrus", "date": "2016-06-01T00:00:00Z"},
+		"3": {"title": "lemon yellow", "tags": "paint", "date": "2017-01-01T00:00:00Z"},
+	}
+	for id, doc := range docs {
+		if err := idx.Index(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q     string
+		total uint64
+		msgs  []string
+		spans [][]Span
+	}{
+		{`lemon`, 2, nil, nil},
+		{``, 0, []string{"the query is empty"}, [][]Span{{{0, 0}}}},
+		{`lemon colour:red`, 2, nil, nil},
+		{`+lemon +colour:red`, 0,
+			[]string{"the field 'colour' has no indexed terms"},
+			[][]Span{{{8, 18}}}},
+		{`date:[2030-01-01 TO ]`, 0,
+			[]string{"`date:[2030-01-01 TO ]` matches 0 documents"},
+			[][]Span{{{0, 21}}}},
+		{`lemon AND tags:citrus AND title:yellow`, 0,
+			[]string{"no document matches both `tags:citrus` and `title:yellow`"},
+			[][]Span{{{10, 21}, {26, 38}}}},
+		{`+tags:citrus -lime -tart`, 0,
+			[]string{"excluding `lime` and `tart` together removes all 2 documents matched by the rest of the query"},
+			[][]Span{{{14, 18}, {20, 24}}}},
+		{`+tags:citrus -title:(lime tart)`, 0,
+			[]string{"excluding `title:(lime tart)` removes all 2 documents matched by the rest of the query"},
+			[][]Span{{{14, 31}}}},
+		{`NOT (lemon lime yellow)`, 0,
+			[]string{"excluding `(lemon lime yellow)` removes all 3 documents matched by the index"},
+			[][]Span{{{4, 23}}}},
+		{`colour:red OR shape:round OR (pickle AND paint)`, 0,
+			[]string{
+				"the field 'colour' has no indexed terms",
+				"the field 'shape' has no indexed terms",
+				"no document matches both `pickle` and `paint`",
+			},
+			[][]Span{{{0, 10}}, {{14, 25}}, {{30, 36}, {41, 46}}}},
+	}
+	for _, test := range tests {
+		d, err := Diagnose(idx, test.q)
+		if err != nil {
+			t.Errorf("`%s`: %s", test.q, err)
+			continue
+		}
+		if d.Total != test.total {
+			t.Errorf("`%s`: expected %d hits, got %d", test.q, test.total, d.Total)
+		}
+		var msgs []string
+		var spans [][]Span
+		for _, prob := range d.Problems {
+			msgs = append(msgs, prob.Msg)
+			spans = append(spans, prob.Spans)
+		}
+		if !reflect.DeepEqual(msgs, test.msgs) || !reflect.DeepEqual(spans, test.spans) {
+			t.Errorf("`%s`: expected %q %v, got %q %v", test.q, test.msgs, test.spans, msgs, spans)
+		}
+	}
+
+	if _, err := Diagnose(idx, `(lemon`); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,376 @@
+package qs
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ChangeKind says what sort of change a Change is.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	}
+	return "changed"
+}
+
+// Change is a difference between two versions of a query, as found by
+// Diff.
+type Change struct {
+	Kind ChangeKind
+	// Old and New are the clauses involved. Old is nil for an addition,
+	// and New is nil for a removal.
+	Old, New Node
+	// OldSpan and NewSpan locate the clauses in the old and new query
+	// strings (zero if there's no clause).
+	OldSpan, NewSpan Span
+	// Desc describes the change, eg "changed date range end from
+	// 2015-01-01 to 2015-06-01"
+	Desc string
+}
+
+func (c Change) String() string { return c.Desc }
+
+// DiffStrings parses two versions of a query with the default Parser,
+// and returns the differences between them. See Parser.Diff.
+func DiffStrings(oldQuery, newQuery string) ([]Change, error) {
+	p := Parser{DefaultOp: OR}
+	return p.DiffStrings(oldQuery, newQuery)
+}
+
+// Diff compares two syntax trees, parsed with the default Parser. See
+// Parser.Diff.
+func Diff(oldTree, newTree Node) []Change {
+	p := Parser{DefaultOp: OR}
+	return p.Diff(oldTree, newTree)
+}
+
+// DiffStrings parses two versions of a query, and returns the differences
+// between them. See Parser.Diff.
+func (p *Parser) DiffStrings(oldQuery, newQuery string) ([]Change, error) {
+	oldTree, err := p.ParseTree(oldQuery)
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := p.ParseTree(newQuery)
+	if err != nil {
+		return nil, err
+	}
+	return p.Diff(oldTree, newTree), nil
+}
+
+// Diff compares two syntax trees and returns the clauses which were added,
+// removed or changed to get from oldTree to newTree. Where a clause has
+// changed in a way that can be pinned down (eg a range end, or a boost),
+// that's what is reported, rather than the whole clause.
+//
+// Differences which don't change the structure of the query are ignored:
+// whitespace, quoting style, redundant parentheses (including grouping
+// within a chain of ANDs or ORs) and ways of writing ranges. Macro
+// references are compared by name and arguments, not their expansions.
+// The order of clauses doesn't matter, and a chain of the Parser's default
+// operator is the same as a list (so with DefaultOp OR, `a OR b` and `a b`
+// are the same).
+func (p *Parser) Diff(oldTree, newTree Node) []Change {
+	d := differ{op: p.DefaultOp}
+	d.diff(d.tidy(oldTree), d.tidy(newTree), "")
+	return d.changes
+}
+
+type differ struct {
+	// op is the default operator for lists
+	op      OpType
+	changes []Change
+}
+
+func (d *differ) add(kind ChangeKind, o, n Node, desc string) {
+	c := Change{Kind: kind, Old: o, New: n, Desc: desc}
+	if o != nil {
+		c.OldSpan = Span{o.Pos(), o.End()}
+	}
+	if n != nil {
+		c.NewSpan = Span{n.Pos(), n.End()}
+	}
+	d.changes = append(d.changes, c)
+}
+
+// diff compares two clauses with the same key. field is the field in
+// scope, if any.
+func (d *differ) diff(o, n Node, field string) {
+	if Format(o) == Format(n) {
+		return
+	}
+	if oc, op, ok := d.members(o); ok {
+		if nc, np, ok := d.members(n); ok && op == np {
+			d.diffClauses(oc, nc, field)
+			return
+		}
+	}
+
+	switch o := o.(type) {
+	case *PrefixExpr:
+		if n, ok := n.(*PrefixExpr); ok && n.Op == o.Op {
+			d.diff(o.X, n.X, field)
+			return
+		}
+	case *NotExpr:
+		if n, ok := n.(*NotExpr); ok {
+			d.diff(o.X, n.X, field)
+			return
+		}
+	case *FieldExpr:
+		if n, ok := n.(*FieldExpr); ok && n.Field == o.Field {
+			d.diff(o.X, n.X, o.Field)
+			return
+		}
+	case *BoostExpr:
+		if n, ok := n.(*BoostExpr); ok {
+			if n.Boost != o.Boost {
+				d.add(Changed, o, n, fmt.Sprintf("changed boost on %s from %s to %s", subject(o.X, field), formatBoost(o.Boost), formatBoost(n.Boost)))
+			}
+			d.diff(o.X, n.X, field)
+			return
+		}
+		d.add(Changed, o, n, fmt.Sprintf("removed boost on %s", subject(o.X, field)))
+		d.diff(o.X, n, field)
+		return
+	case *RangeExpr:
+		if n, ok := n.(*RangeExpr); ok {
+			d.diffRange(o, n, field)
+			return
+		}
+	case *Term:
+		if n, ok := n.(*Term); ok && n.Text == o.Text {
+			d.add(Changed, o, n, fmt.Sprintf("changed fuzziness of %s from %s to %s", subject(o, field), fuzziness(o), fuzziness(n)))
+			return
+		}
+	}
+	if n, ok := n.(*BoostExpr); ok {
+		d.add(Changed, o, n, fmt.Sprintf("added boost ^%s on %s", formatBoost(n.Boost), subject(n.X, field)))
+		d.diff(o, n.X, field)
+		return
+	}
+	d.add(Changed, o, n, fmt.Sprintf("changed `%s` to `%s`", Format(o), Format(n)))
+}
+
+// diffClauses pairs up the clauses of two lists or chains
+func (d *differ) diffClauses(olds, news []Node, field string) {
+	matched := make([]bool, len(news))
+	pending := []Node{}
+	// identical clauses first, wherever they are
+	for _, o := range olds {
+		found := false
+		for j, n := range news {
+			if !matched[j] && Format(o) == Format(n) {
+				matched[j], found = true, true
+				break
+			}
+		}
+		if !found {
+			pending = append(pending, o)
+		}
+	}
+	// then clauses which look like versions of each other
+	for _, o := range pending {
+		found := false
+		for j, n := range news {
+			if !matched[j] && clauseKey(o) == clauseKey(n) {
+				matched[j], found = true, true
+				d.diff(o, n, field)
+				break
+			}
+		}
+		if !found {
+			d.add(Removed, o, nil, fmt.Sprintf("removed `%s`", Format(o)))
+		}
+	}
+	for j, n := range news {
+		if !matched[j] {
+			d.add(Added, nil, n, fmt.Sprintf("added `%s`", Format(n)))
+		}
+	}
+}
+
+func (d *differ) diffRange(o, n *RangeExpr, field string) {
+	what := "range"
+	if field != "" {
+		what = field + " range"
+	}
+	end := func(name, oldVal, newVal string, oldInc, newInc bool) {
+		switch {
+		case oldVal == "" && newVal != "":
+			d.add(Changed, o, n, fmt.Sprintf("added %s %s %s", what, name, newVal))
+		case oldVal != "" && newVal == "":
+			d.add(Changed, o, n, fmt.Sprintf("removed %s %s %s", what, name, oldVal))
+		case oldVal != newVal:
+			d.add(Changed, o, n, fmt.Sprintf("changed %s %s from %s to %s", what, name, oldVal, newVal))
+		}
+		if oldVal != "" && newVal != "" && oldInc != newInc {
+			incl := "exclusive"
+			if newInc {
+				incl = "inclusive"
+			}
+			d.add(Changed, o, n, fmt.Sprintf("made %s %s %s", what, name, incl))
+		}
+	}
+	end("start", o.Min, n.Min, o.MinInclusive, n.MinInclusive)
+	end("end", o.Max, n.Max, o.MaxInclusive, n.MaxInclusive)
+}
+
+// members returns the clauses of a list or AND/OR chain, along with a
+// string saying how they're combined.
+func (d *differ) members(n Node) ([]Node, string, bool) {
+	switch n := n.(type) {
+	case *List:
+		return n.Clauses, "list", true
+	case *Group:
+		return n.X.Clauses, "list", true
+	case *BoolExpr:
+		if d.listLike(n) {
+			return n.Operands, "list", true
+		}
+		if n.Op == AND {
+			return n.Operands, "AND", true
+		}
+		return n.Operands, "OR", true
+	}
+	return nil, "", false
+}
+
+// clauseKey identifies what a clause is about, ignoring the details
+// which Diff reports as changes (prefixes, boosts, range values...)
+func clauseKey(n Node) string {
+	switch n := n.(type) {
+	case *PrefixExpr:
+		return clauseKey(n.X)
+	case *BoostExpr:
+		return clauseKey(n.X)
+	case *NotExpr:
+		return "NOT " + clauseKey(n.X)
+	case *FieldExpr:
+		return n.Field + ":" + clauseKey(n.X)
+	case *RangeExpr:
+		return "range"
+	case *Term:
+		return "term " + n.Text
+	case *Phrase:
+		return "phrase " + n.Text
+	case *MacroExpr:
+		return "@" + n.Name
+	case *TermsLookup:
+		return "@list"
+	}
+	return "group"
+}
+
+// subject describes what a clause applies to, for change descriptions
+func subject(n Node, field string) string {
+	if f, ok := n.(*FieldExpr); ok {
+		return f.Field
+	}
+	if field != "" {
+		return field
+	}
+	return "`" + Format(n) + "`"
+}
+
+func formatBoost(b float64) string {
+	return strconv.FormatFloat(b, 'f', -1, 64)
+}
+
+func fuzziness(t *Term) string {
+	if !t.Fuzzy {
+		return "none"
+	}
+	return strconv.Itoa(t.Fuzziness)
+}
+
+// listLike reports whether a chain means the same as a list of its
+// operands: it uses the default operator, and none of its operands are
+// prefixed or negated (`a OR -b` isn't `a -b`).
+func (d *differ) listLike(b *BoolExpr) bool {
+	if b.Op != d.op {
+		return false
+	}
+	for _, operand := range b.Operands {
+		switch operand.(type) {
+		case *PrefixExpr, *NotExpr:
+			return false
+		}
+	}
+	return true
+}
+
+// tidy returns a copy of a tree without the cosmetic differences Diff
+// ignores: groups of a single unprefixed clause are unwrapped, groups
+// within a chain of the same operator are merged into it, and chains of
+// the default operator are merged into lists. Spans are kept.
+func (d *differ) tidy(n Node) Node {
+	switch n := n.(type) {
+	case *List:
+		l := d.tidyList(n)
+		if len(l.Clauses) == 1 {
+			if _, ok := l.Clauses[0].(*PrefixExpr); !ok {
+				return l.Clauses[0]
+			}
+		}
+		return l
+	case *Group:
+		if len(n.X.Clauses) == 1 {
+			// (-a) isn't -a
+			if _, ok := n.X.Clauses[0].(*PrefixExpr); !ok {
+				return d.tidy(n.X.Clauses[0])
+			}
+		}
+		return &Group{Span: n.Span, X: d.tidyList(n.X)}
+	case *BoolExpr:
+		out := &BoolExpr{Span: n.Span, Op: n.Op}
+		for _, operand := range n.Operands {
+			operand = d.tidy(operand)
+			if b, ok := operand.(*BoolExpr); ok && b.Op == n.Op {
+				out.Operands = append(out.Operands, b.Operands...)
+			} else {
+				out.Operands = append(out.Operands, operand)
+			}
+		}
+		return out
+	case *NotExpr:
+		return &NotExpr{Span: n.Span, X: d.tidy(n.X)}
+	case *PrefixExpr:
+		return &PrefixExpr{Span: n.Span, Op: n.Op, X: d.tidy(n.X)}
+	case *FieldExpr:
+		return &FieldExpr{Span: n.Span, Field: n.Field, X: d.tidy(n.X)}
+	case *BoostExpr:
+		return &BoostExpr{Span: n.Span, X: d.tidy(n.X), Boost: n.Boost, BoostPos: n.BoostPos}
+	}
+	return n
+}
+
+func (d *differ) tidyList(l *List) *List {
+	out := &List{Span: l.Span}
+	for _, clause := range l.Clauses {
+		clause = d.tidy(clause)
+		if b, ok := clause.(*BoolExpr); ok && len(l.Clauses) > 1 && d.listLike(b) {
+			out.Clauses = append(out.Clauses, b.Operands...)
+		} else {
+			out.Clauses = append(out.Clauses, clause)
+		}
+	}
+	// ((a b)) is just a b
+	if len(out.Clauses) == 1 {
+		if g, ok := out.Clauses[0].(*Group); ok {
+			return g.X
+		}
+	}
+	return out
+}
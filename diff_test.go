@@ -0,0 +1,92 @@
+package qs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	data := []struct {
+		old, new string
+		expected []string
+	}{
+		{`a  b`, `(b) a`, nil},
+		{`a AND (b AND c)`, `a AND b AND c`, nil},
+		{`price:[10 TO ] 'x'`, `price:>=10 "x"`, nil},
+		{`((a b))`, `a b`, nil},
+		{`title:lemon^2`, `title:lemon`, []string{"removed boost on title"}},
+		{`title:lemon`, `title:lemon^2`, []string{"added boost ^2 on title"}},
+		{`lemon^2`, `lemon^3`, []string{"changed boost on `lemon` from 2 to 3"}},
+		{`fruit date:[2014-01-01 TO 2015-01-01]`, `fruit date:[2014-01-01 TO 2015-06-01] +tags:citrus`, []string{
+			"changed date range end from 2015-01-01 to 2015-06-01",
+			"added `+tags:citrus`",
+		}},
+		{`price:>10`, `price:[10 TO 20]`, []string{
+			"made price range start inclusive",
+			"added price range end 20",
+		}},
+		{`a b c`, `a c`, []string{"removed `b`"}},
+		{`+a`, `-a`, []string{"changed `+a` to `-a`"}},
+		{`a AND b`, `a OR b`, []string{"changed `a AND b` to `a OR b`"}},
+		{`x:(a b) y`, `x:(a c) y`, []string{"removed `b`", "added `c`"}},
+		{`lemon~1`, `lemon~2`, []string{"changed fuzziness of `lemon~1` from 1 to 2"}},
+		{`NOT title:"big lemon"^2`, `NOT title:"big lemon"`, []string{"removed boost on title"}},
+		{`a OR b`, `b a`, nil},
+		{`(a b) AND c`, `c AND (a OR b)`, nil},
+		{`x (-a)`, `x -a`, []string{"removed `(-a)`", "added `-a`"}},
+		{`x (+a)`, `x +a`, []string{"removed `(+a)`", "added `+a`"}},
+		{`a OR -b`, `a -b`, []string{"changed `a OR -b` to `a -b`"}},
+	}
+
+	for _, dat := range data {
+		changes, err := DiffStrings(dat.old, dat.new)
+		if err != nil {
+			t.Fatalf("`%s` -> `%s`: %s", dat.old, dat.new, err)
+		}
+		var got []string
+		for _, c := range changes {
+			got = append(got, c.Desc)
+		}
+		if !reflect.DeepEqual(got, dat.expected) {
+			t.Errorf("`%s` -> `%s`: expected %q, got %q", dat.old, dat.new, dat.expected, got)
+		}
+	}
+
+	// macros are compared by reference, not expansion
+	p := Parser{Macros: testMacros}
+	t1, _ := p.ParseTree(`pubdate:@recent(7d)`)
+	t2, _ := p.ParseTree(`pubdate:@recent(30d)`)
+	changes := Diff(t1, t2)
+	if len(changes) != 1 || changes[0].Desc != "changed `@recent(7d)` to `@recent(30d)`" {
+		t.Errorf("unexpected macro changes %v", changes)
+	}
+}
+
+func TestDiffSpans(t *testing.T) {
+	old := `fruit  date:[2014-01-01 TO 2015-01-01] title:x^2`
+	new := `title:x fruit date:[2014-01-01 TO 2015-06-01] +tags:citrus`
+	changes, err := DiffStrings(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Kind: Changed, OldSpan: Span{12, 38}, NewSpan: Span{19, 45}},
+		{Kind: Changed, OldSpan: Span{39, 48}, NewSpan: Span{0, 7}},
+		{Kind: Added, NewSpan: Span{46, 58}},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %v", len(expected), changes)
+	}
+	for i, c := range changes {
+		e := expected[i]
+		if c.Kind != e.Kind || c.OldSpan != e.OldSpan || c.NewSpan != e.NewSpan {
+			t.Errorf("%d: expected %s %v %v, got %s %v %v (%s)", i, e.Kind, e.OldSpan, e.NewSpan, c.Kind, c.OldSpan, c.NewSpan, c)
+		}
+		if c.Old != nil && old[c.OldSpan.From:c.OldSpan.To] == "" {
+			t.Errorf("%d: empty old span", i)
+		}
+	}
+	if changes[2].Old != nil || changes[2].New == nil {
+		t.Errorf("expected an added node")
+	}
+}
@@ -0,0 +1,377 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve/search/query"
+	"reflect"
+)
+
+// Comparing the meaning of queries.
+//
+// Equivalent and Subsumes compare the sets of documents two queries could
+// match, for any index. Boosts are ignored. The checks work on normalized
+// queries using the rules of boolean logic, plus what's known about ranges
+// and term sets, so the answer is often Unknown. In particular, terms are
+// only known to be the same if they're identical, as it's up to the index
+// mapping how text is analysed (eg lemon and LEMON may or may not be the
+// same), and queries on different fields are never comparable (the default
+// field may include the others).
+//
+// The answer is only guaranteed to be right for fields holding one value
+// per document. Like range merging, comparing ranges assumes that, so
+// `x:[1 TO 2] AND x:[5 TO 6]` is taken to match nothing, although it can
+// match a document with several values for x.
+
+// Truth is the answer to a question which can't always be decided.
+type Truth int
+
+const (
+	Unknown Truth = iota
+	True
+	False
+)
+
+func (t Truth) String() string {
+	switch t {
+	case True:
+		return "true"
+	case False:
+		return "false"
+	}
+	return "unknown"
+}
+
+// Equivalent reports whether two query strings match the same documents,
+// using the default Parser.
+func Equivalent(q1, q2 string) (Truth, error) {
+	p := Parser{DefaultOp: OR}
+	return p.Equivalent(q1, q2)
+}
+
+// Subsumes reports whether every document matched by q2 is also matched
+// by q1 (ie q2 is the same as, or narrower than, q1), using the default
+// Parser.
+func Subsumes(q1, q2 string) (Truth, error) {
+	p := Parser{DefaultOp: OR}
+	return p.Subsumes(q1, q2)
+}
+
+// Equivalent reports whether two query strings match the same documents,
+// eg `a OR b` and `b a`. Ranges are compared assuming a field holds one
+// value per document.
+func (p *Parser) Equivalent(q1, q2 string) (Truth, error) {
+	a, b, err := p.parsePair(q1, q2)
+	if err != nil {
+		return Unknown, err
+	}
+	return EquivalentQueries(a, b), nil
+}
+
+// Subsumes reports whether every document matched by q2 is also matched
+// by q1, eg `a OR b` subsumes `a`, and `price:<100` subsumes
+// `price:[10 TO 20]` (if price is single-valued).
+func (p *Parser) Subsumes(q1, q2 string) (Truth, error) {
+	a, b, err := p.parsePair(q1, q2)
+	if err != nil {
+		return Unknown, err
+	}
+	return SubsumesQueries(a, b), nil
+}
+
+func (p *Parser) parsePair(q1, q2 string) (query.Query, query.Query, error) {
+	a, err := p.Parse(q1)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := p.Parse(q2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// EquivalentQueries reports whether two bleve queries match the same
+// documents.
+func EquivalentQueries(q1, q2 query.Query) Truth {
+	a, b := logicOf(q1), logicOf(q2)
+	return both(subsumes(a, b), subsumes(b, a))
+}
+
+// SubsumesQueries reports whether every document matched by q2 is also
+// matched by q1.
+func SubsumesQueries(q1, q2 query.Query) Truth {
+	return subsumes(logicOf(q1), logicOf(q2))
+}
+
+// logic is a query reduced to its boolean structure
+type logic struct {
+	op   int
+	q    query.Query // for logicLeaf
+	kids []*logic
+	key  string
+}
+
+const (
+	logicLeaf = iota
+	logicAll
+	logicNone
+	logicAnd
+	logicOr
+	logicNot
+)
+
+// logicOf normalizes q (without boosts) and returns its structure
+func logicOf(q query.Query) *logic {
+	q = copyQuery(q)
+	clearBoosts(reflect.ValueOf(q))
+	return toLogic(normalize(q))
+}
+
+func toLogic(q query.Query) *logic {
+	l := &logic{op: logicLeaf, q: q, key: queryKey(q)}
+	switch q := q.(type) {
+	case *query.MatchAllQuery:
+		l.op = logicAll
+	case *query.MatchNoneQuery:
+		l.op = logicNone
+	case *query.ConjunctionQuery:
+		l.op = logicAnd
+		for _, sub := range q.Conjuncts {
+			l.kids = append(l.kids, toLogic(sub))
+		}
+	case *query.DisjunctionQuery:
+		if q.Min <= 1 {
+			l.op = logicOr
+			for _, sub := range q.Disjuncts {
+				l.kids = append(l.kids, toLogic(sub))
+			}
+		}
+	case *query.BooleanQuery:
+		// after normalizing, any shoulds are optional and don't affect
+		// what's matched
+		l.op = logicAnd
+		if q.Must != nil {
+			l.kids = append(l.kids, toLogic(q.Must).flatten(logicAnd)...)
+		}
+		if q.MustNot != nil {
+			for _, sub := range toLogic(q.MustNot).flatten(logicOr) {
+				l.kids = append(l.kids, &logic{op: logicNot, kids: []*logic{sub}, key: "NOT " + sub.key})
+			}
+		}
+		if len(l.kids) == 1 && l.kids[0].op == logicNot {
+			return l.kids[0]
+		}
+	}
+	return l
+}
+
+// flatten returns the operands if l is an op expression, else l itself
+func (l *logic) flatten(op int) []*logic {
+	if l.op == op {
+		return l.kids
+	}
+	return []*logic{l}
+}
+
+// contradictory returns true if l is a conjunction which requires and
+// excludes the same thing, eg x AND NOT (x OR y)
+func (l *logic) contradictory() bool {
+	if l.op != logicAnd {
+		return false
+	}
+	for _, not := range l.kids {
+		if not.op != logicNot {
+			continue
+		}
+		for _, kid := range l.kids {
+			if kid.op != logicNot && subsumes(not.kids[0], kid) == True {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subsumes reports whether a matches everything b does
+func subsumes(a, b *logic) Truth {
+	switch {
+	case a.key == b.key, a.op == logicAll, b.op == logicNone, b.contradictory():
+		return True
+	case b.op == logicOr:
+		// every alternative must be covered
+		t := True
+		for _, kid := range b.kids {
+			t = both(t, subsumes(a, kid))
+		}
+		return t
+	case a.op == logicAnd:
+		// every condition must be met
+		t := True
+		for _, kid := range a.kids {
+			t = both(t, subsumes(kid, b))
+		}
+		return t
+	}
+	if a.op == logicOr {
+		for _, kid := range a.kids {
+			if subsumes(kid, b) == True {
+				return True
+			}
+		}
+	}
+	if b.op == logicAnd {
+		for _, kid := range b.kids {
+			if subsumes(a, kid) == True {
+				return True
+			}
+		}
+	}
+	switch {
+	case a.op == logicNot && b.op == logicNot:
+		return subsumes(b.kids[0], a.kids[0])
+	case a.op == logicNot:
+		if disjoint(a.kids[0], b) == True {
+			return True
+		}
+	case a.op == logicLeaf && b.op == logicLeaf:
+		return leafSubsumes(a.q, b.q)
+	}
+	return Unknown
+}
+
+// disjoint reports whether nothing matches both a and b. It never
+// returns False.
+func disjoint(a, b *logic) Truth {
+	if disjointBy(a, b) == True || disjointBy(b, a) == True {
+		return True
+	}
+	return Unknown
+}
+
+// disjointBy checks disjoint(a, b) using the structure of a
+func disjointBy(a, b *logic) Truth {
+	switch a.op {
+	case logicNone:
+		return True
+	case logicOr:
+		for _, kid := range a.kids {
+			if disjoint(kid, b) != True {
+				return Unknown
+			}
+		}
+		return True
+	case logicAnd:
+		for _, kid := range a.kids {
+			if disjoint(kid, b) == True {
+				return True
+			}
+		}
+	case logicNot:
+		return subsumes(a.kids[0], b).only(True)
+	case logicLeaf:
+		if b.op == logicLeaf {
+			return leafDisjoint(a.q, b.q)
+		}
+	}
+	return Unknown
+}
+
+// leafSubsumes compares ranges and term sets on the same field
+func leafSubsumes(a, b query.Query) Truth {
+	if ia, ok := toInterval(a); ok {
+		ib, ok := toInterval(b)
+		if !ok || ia.kind != ib.kind || ia.field != ib.field {
+			return Unknown
+		}
+		if ib.empty() || contains(ia, ib) {
+			return True
+		}
+		// there's a value in b which isn't in a
+		return False
+	}
+	sa, ok1 := a.(*TermSetQuery)
+	sb, ok2 := b.(*TermSetQuery)
+	if ok1 && ok2 && sa.FieldVal == sb.FieldVal {
+		for _, term := range sb.Terms {
+			if !sa.has(term) {
+				return False
+			}
+		}
+		return True
+	}
+	return Unknown
+}
+
+// leafDisjoint compares ranges on the same field
+func leafDisjoint(a, b query.Query) Truth {
+	ia, ok1 := toInterval(a)
+	ib, ok2 := toInterval(b)
+	if ok1 && ok2 && ia.kind == ib.kind && ia.field == ib.field && intersect(ia, ib).empty() {
+		return True
+	}
+	return Unknown
+}
+
+// contains returns true if every value in b is also in a
+func contains(a, b interval) bool {
+	if a.lo != nil {
+		if b.lo == nil {
+			return false
+		}
+		c := compareValues(a.lo.v, b.lo.v)
+		if c > 0 || (c == 0 && !a.lo.inc && b.lo.inc) {
+			return false
+		}
+	}
+	if a.hi != nil {
+		if b.hi == nil {
+			return false
+		}
+		c := compareValues(a.hi.v, b.hi.v)
+		if c < 0 || (c == 0 && !a.hi.inc && b.hi.inc) {
+			return false
+		}
+	}
+	return true
+}
+
+// both combines the answers to two questions which must both be true
+func both(a, b Truth) Truth {
+	switch {
+	case a == False || b == False:
+		return False
+	case a == True && b == True:
+		return True
+	}
+	return Unknown
+}
+
+// only returns t if it's want, else Unknown
+func (t Truth) only(want Truth) Truth {
+	if t == want {
+		return t
+	}
+	return Unknown
+}
+
+// clearBoosts removes all the boosts from a query tree, in place
+func clearBoosts(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			clearBoosts(v.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			clearBoosts(v.Index(i))
+		}
+	case reflect.Struct:
+		if f := v.FieldByName("BoostVal"); f.IsValid() && f.CanSet() && f.Kind() == reflect.Ptr {
+			f.Set(reflect.Zero(f.Type()))
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() && (f.Kind() == reflect.Interface || f.Kind() == reflect.Slice) {
+				clearBoosts(f)
+			}
+		}
+	}
+}
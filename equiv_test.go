@@ -0,0 +1,97 @@
+package qs
+
+import (
+	"testing"
+)
+
+func TestEquivalent(t *testing.T) {
+	data := []struct {
+		q1, q2   string
+		expected Truth
+	}{
+		{`a OR b`, `b a`, True},
+		{`a AND b`, `+b +a`, True},
+		{`a^2 b`, `a b^3`, True},
+		{`NOT (NOT a OR NOT b)`, `a AND b`, True},
+		{`a -b`, `a AND NOT b`, True},
+		{`title:(a b)`, `title:a OR title:b`, True},
+		{`price:>10 AND price:<=50 AND price:[20 TO 100]`, `price:[20 TO 50]`, True},
+		{`price:>50 AND price:<10`, `price:>50 AND NOT price:>=0`, True},
+		{`price:[1 TO 5] OR price:[3 TO 9]`, `price:[1 TO 9]`, True},
+		{`price:[1 TO 5]`, `price:[1 TO 9]`, False},
+		{`date:>=2016-01-01 AND date:<2016-02-01`, `date:[2016-01-01 TO 2016-01-31]`, True},
+		{`price:[1 TO 5]`, `price:[1 TO 5}`, False},
+		{`a`, `b`, Unknown},
+		{`a`, `A`, Unknown},
+		{`title:a`, `a`, Unknown},
+		{`a b`, `a`, Unknown},
+	}
+	for _, dat := range data {
+		got, err := Equivalent(dat.q1, dat.q2)
+		if err != nil {
+			t.Fatalf("`%s` vs `%s`: %s", dat.q1, dat.q2, err)
+		}
+		if got != dat.expected {
+			t.Errorf("`%s` vs `%s`: expected %s, got %s", dat.q1, dat.q2, dat.expected, got)
+		}
+	}
+}
+
+func TestSubsumes(t *testing.T) {
+	data := []struct {
+		q1, q2   string
+		expected Truth
+	}{
+		{`a OR b`, `a`, True},
+		{`a`, `a AND b`, True},
+		{`a b`, `a +b`, True},
+		{`a`, `a OR b`, Unknown},
+		{`price:<100`, `price:[10 TO 20]`, True},
+		{`price:[10 TO 20]`, `price:<100`, False},
+		{`price:<100`, `price:[10 TO 20] OR price:[50 TO 60]`, True},
+		{`price:<100`, `price:[10 TO 20] OR price:[50 TO 600]`, False},
+		{`price:<100 AND a`, `price:[10 TO 20] AND a AND b`, True},
+		{`price:<100 AND a`, `price:[10 TO 200] AND a`, Unknown},
+		{`NOT a`, `NOT (a OR b)`, True},
+		{`NOT a`, `NOT a AND NOT b`, True},
+		{`NOT b`, `a`, Unknown},
+		{`NOT price:>100`, `price:[10 TO 20]`, True},
+		{`-price:<10 -price:>20`, `price:[10 TO 20]`, True},
+		{`title:a`, `title:(a AND b)`, True},
+		{`a`, `title:a`, Unknown},
+		{`a`, `price:>10 AND price:<5`, True},
+		{`a`, `b AND price:>10 AND NOT price:>5`, True},
+		{`*`, `a`, Unknown},
+	}
+	for _, dat := range data {
+		got, err := Subsumes(dat.q1, dat.q2)
+		if err != nil {
+			t.Fatalf("`%s` vs `%s`: %s", dat.q1, dat.q2, err)
+		}
+		if got != dat.expected {
+			t.Errorf("`%s` vs `%s`: expected %s, got %s", dat.q1, dat.q2, dat.expected, got)
+		}
+	}
+
+	p := Parser{Mapping: keywordMapping(), Lists: TermListMap{"small": {"1", "2"}, "big": {"1", "2", "3"}}}
+	for _, dat := range []struct {
+		q1, q2   string
+		expected Truth
+	}{
+		{`id:@list(big)`, `id:@list(small)`, True},
+		{`id:@list(small)`, `id:@list(big)`, False},
+		{`id:(1 2 3)`, `id:(3 1)`, True},
+	} {
+		got, err := p.Subsumes(dat.q1, dat.q2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != dat.expected {
+			t.Errorf("`%s` vs `%s`: expected %s, got %s", dat.q1, dat.q2, dat.expected, got)
+		}
+	}
+
+	if _, err := Subsumes(`a`, `(b`); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,316 @@
+package qs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Explain describes in plain English what a query matches, using the
+// default Parser. See Parser.Explain.
+func Explain(q string) (string, error) {
+	p := Parser{DefaultOp: OR}
+	return p.Explain(q)
+}
+
+// Explain describes in plain English what a query will match, eg:
+//
+//   Documents whose date is on or after 1 Jan 2015, excluding those whose
+//   tags contain 'paint' (those whose title contains the phrase 'navel
+//   orange' rank higher).
+//
+// The description follows the query as compiled, so it spells out the
+// effect of the default operator and of + and - prefixes. Where part of
+// the query doesn't mean what it looks like (eg a - prefix inside an OR
+// expression, which is treated as NOT), a note on a separate line says so.
+//
+// Returns an error if the query doesn't parse or compile.
+func (p *Parser) Explain(q string) (string, error) {
+	tree, err := p.ParseTree(q)
+	if err != nil {
+		return "", err
+	}
+	if _, err := p.Compile(tree); err != nil {
+		return "", err
+	}
+	e := explainer{p: p}
+	l := tree.(*List)
+	var desc string
+	switch {
+	case len(l.Clauses) == 0:
+		desc = "No documents."
+	default:
+		text, _ := e.list(l.Clauses, "", false)
+		if strings.HasPrefix(text, "excluding") {
+			desc = "All documents, " + text + "."
+		} else {
+			desc = "Documents " + text + "."
+		}
+	}
+	for _, note := range e.notes {
+		desc += "\nNote: " + note
+	}
+	return desc, nil
+}
+
+type explainer struct {
+	p     *Parser
+	notes []string
+}
+
+func (e *explainer) note(format string, args ...interface{}) {
+	e.notes = append(e.notes, fmt.Sprintf(format, args...))
+}
+
+// describe returns a phrase (following "documents") saying what n matches,
+// or doesn't match if neg is set. The bool is true if the phrase is made
+// up of several conditions, so needs parentheses inside another one.
+func (e *explainer) describe(n Node, field string, neg bool) (string, bool) {
+	switch n := n.(type) {
+	case *List:
+		return e.list(n.Clauses, field, neg)
+	case *Group:
+		return e.list(n.X.Clauses, field, neg)
+	case *MacroExpr:
+		return e.list(n.X.Clauses, field, neg)
+	case *BoolExpr:
+		return e.boolExpr(n, field, neg)
+	case *NotExpr:
+		x := n.X
+		if pe, ok := x.(*PrefixExpr); ok {
+			x = pe.X
+			if pe.Op == '-' {
+				e.note("`NOT %s` is a double negative, so it matches %s", Format(pe), Format(x))
+				return e.describe(x, field, neg)
+			}
+			e.note("the + in `NOT %s` has no effect", Format(pe))
+		}
+		return e.describe(x, field, !neg)
+	case *PrefixExpr:
+		if n.Op == '-' {
+			return e.describe(n.X, field, !neg)
+		}
+		return e.describe(n.X, field, neg)
+	case *FieldExpr:
+		return e.describe(n.X, n.Field, neg)
+	case *BoostExpr:
+		text, compound := e.describe(n.X, field, neg)
+		if compound {
+			text = "(" + text + ")"
+		}
+		return text + " (weighted x" + strconv.FormatFloat(n.Boost, 'f', -1, 64) + ")", false
+	case *Term:
+		return fieldContains(field, termDesc(n), neg), false
+	case *Phrase:
+		what := "'" + n.Text + "'"
+		if strings.ContainsAny(n.Text, " \t") {
+			what = "the phrase " + what
+		}
+		return fieldContains(field, what, neg), false
+	case *TermsLookup:
+		if field == "" {
+			return fieldContains("", "a term from the list '"+n.Name+"'", neg), false
+		}
+		return fieldIs(field, "one of the terms in the list '"+n.Name+"'", neg), false
+	case *RangeExpr:
+		return fieldIs(field, rangeDesc(n, e.p), neg), false
+	}
+	return Format(n), false
+}
+
+// list describes a sequence of clauses combined using prefixes and the
+// default operator, as compileList does.
+func (e *explainer) list(clauses []Node, field string, neg bool) (string, bool) {
+	if len(clauses) == 1 {
+		if _, ok := clauses[0].(*PrefixExpr); !ok {
+			return e.describe(clauses[0], field, neg)
+		}
+	}
+	var must, should, mustNot []phrase
+	for _, clause := range clauses {
+		prefix := rune(0)
+		if pe, ok := clause.(*PrefixExpr); ok {
+			prefix = pe.Op
+			clause = pe.X
+		}
+		if not, ok := clause.(*NotExpr); ok && prefix == 0 && e.p.DefaultOp == OR && len(clauses) > 1 {
+			e.note("`%s` is one of the alternatives rather than an exclusion, so it matches anything without %s - use -%s to exclude",
+				Format(not), Format(not.X), Format(not.X))
+		}
+		var ph phrase
+		ph.text, ph.compound = e.describe(clause, field, false)
+		switch {
+		case prefix == '+', prefix == 0 && e.p.DefaultOp == AND:
+			must = append(must, ph)
+		case prefix == '-':
+			mustNot = append(mustNot, ph)
+		default:
+			should = append(should, ph)
+		}
+	}
+
+	var text string
+	switch {
+	case len(must) > 0:
+		text = joinCompound(must, "and")
+	case len(should) > 0:
+		text = joinCompound(should, "or")
+	}
+	if len(mustNot) > 0 {
+		if text != "" {
+			text += ", "
+		}
+		text += "excluding those " + joinCompound(mustNot, "or")
+	}
+	if len(must) > 0 && len(should) > 0 {
+		text += " (those " + joinCompound(should, "or") + " rank higher)"
+	}
+	compound := len(must)+len(mustNot) > 1 || (len(must) == 0 && len(should) > 1) || len(mustNot) > 0
+	if neg {
+		return "not (" + text + ")", false
+	}
+	return text, compound
+}
+
+// boolExpr describes an AND or OR chain, as compileBool does
+func (e *explainer) boolExpr(b *BoolExpr, field string, neg bool) (string, bool) {
+	opName, join := "AND", "and"
+	if b.Op == OR {
+		opName, join = "OR", "or"
+	}
+	// NOT (a AND b) => not a or not b
+	if neg {
+		if b.Op == AND {
+			join = "or"
+		} else {
+			join = "and"
+		}
+	}
+	parts := make([]string, len(b.Operands))
+	for i, operand := range b.Operands {
+		if pe, ok := operand.(*PrefixExpr); ok {
+			if pe.Op == '+' {
+				e.note("`%s` is part of an %s expression, so the + has no effect", Format(pe), opName)
+			} else {
+				e.note("`%s` is part of an %s expression, so it's treated as NOT %s", Format(pe), opName, Format(pe.X))
+			}
+		}
+		text, compound := e.describe(operand, field, neg)
+		if compound {
+			text = "(" + text + ")"
+		}
+		parts[i] = text
+	}
+	return joinPhrases(parts, join), len(parts) > 1
+}
+
+// fieldContains describes a condition on the content of a field
+func fieldContains(field, what string, neg bool) string {
+	switch {
+	case field == "" && neg:
+		return "not containing " + what
+	case field == "":
+		return "containing " + what
+	case neg:
+		return "whose " + field + verb(field, " doesn't contain ", " don't contain ") + what
+	}
+	return "whose " + field + verb(field, " contains ", " contain ") + what
+}
+
+// fieldIs describes a condition on the value of a field
+func fieldIs(field, what string, neg bool) string {
+	switch {
+	case field == "" && neg:
+		return "without a value " + what
+	case field == "":
+		return "with a value " + what
+	case neg:
+		return "whose " + field + verb(field, " isn't ", " aren't ") + what
+	}
+	return "whose " + field + verb(field, " is ", " are ") + what
+}
+
+// verb picks the singular or plural form to suit a field name
+// (eg "whose title contains", "whose tags contain")
+func verb(field, singular, plural string) string {
+	if strings.HasSuffix(field, "s") && !strings.HasSuffix(field, "ss") {
+		return plural
+	}
+	return singular
+}
+
+func termDesc(t *Term) string {
+	what := "'" + t.Text + "'"
+	switch {
+	case containsWildcard(t.Text):
+		return "a word matching " + what
+	case t.Fuzzy && t.Fuzziness == 1:
+		return what + " (or a word within 1 edit of it)"
+	case t.Fuzzy:
+		return fmt.Sprintf("%s (or a word within %d edits of it)", what, t.Fuzziness)
+	}
+	return what
+}
+
+// rangeDesc describes the values in a range, eg "on or after 1 Jan 2015"
+func rangeDesc(r *RangeExpr, p *Parser) string {
+	rp := newRangeParams(r.Min, r.Max, r.MinInclusive, r.MaxInclusive, p.Loc)
+	isNumeric, _, _ := rp.numericArgs()
+	bound := func(v string, inclusive bool, numWords, dateWords [2]string) string {
+		i := 0
+		if inclusive {
+			i = 1
+		}
+		if isNumeric {
+			return numWords[i] + v
+		}
+		if t, prec := parseTime(v, rp.loc); prec != "" {
+			v = t.Format("2 Jan 2006")
+		}
+		return dateWords[i] + v
+	}
+
+	var lower, upper string
+	if r.Min != "" {
+		lower = bound(r.Min, r.MinInclusive, [2]string{"more than ", "at least "}, [2]string{"after ", "on or after "})
+	}
+	if r.Max != "" {
+		upper = bound(r.Max, r.MaxInclusive, [2]string{"less than ", "at most "}, [2]string{"before ", "on or before "})
+	}
+	switch {
+	case lower == "":
+		return upper
+	case upper == "":
+		return lower
+	}
+	return lower + " and " + upper
+}
+
+// phrase is a description of a clause, which needs parentheses if it's
+// compound and joined with others
+type phrase struct {
+	text     string
+	compound bool
+}
+
+func joinCompound(phrases []phrase, conj string) string {
+	texts := make([]string, len(phrases))
+	for i, ph := range phrases {
+		texts[i] = ph.text
+		if ph.compound && len(phrases) > 1 {
+			texts[i] = "(" + ph.text + ")"
+		}
+	}
+	return joinPhrases(texts, conj)
+}
+
+// joinPhrases joins phrases into a list, eg "a, b or c"
+func joinPhrases(phrases []string, conj string) string {
+	switch len(phrases) {
+	case 0:
+		return ""
+	case 1:
+		return phrases[0]
+	}
+	return strings.Join(phrases[:len(phrases)-1], ", ") + " " + conj + " " + phrases[len(phrases)-1]
+}
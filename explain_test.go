@@ -0,0 +1,59 @@
+package qs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func ExampleExplain() {
+	desc, _ := Explain(`title:"navel orange" AND date:>=2015-01-01 -tags:paint`)
+	fmt.Println(desc)
+	// Output:
+	// Documents whose title contains the phrase 'navel orange' and whose date is on or after 1 Jan 2015, excluding those whose tags contain 'paint'.
+}
+
+func TestExplain(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`lemon lime`, `Documents containing 'lemon' or containing 'lime'.`},
+		{`+lemon lime`, `Documents containing 'lemon' (those containing 'lime' rank higher).`},
+		{`-kumquat`, `All documents, excluding those containing 'kumquat'.`},
+		{`NOT (a AND b)`, `Documents not containing 'a' or not containing 'b'.`},
+		{`date:<2015-02-01 price:{10 TO 20]`, `Documents whose date is before 1 Feb 2015 or whose price is more than 10 and at most 20.`},
+		{`lem* lemon~1 "x"^2`, `Documents containing a word matching 'lem*', containing 'lemon' (or a word within 1 edit of it) or containing 'x' (weighted x2).`},
+		{`(a b) -(c d)`, `Documents containing 'a' or containing 'b', excluding those containing 'c' or containing 'd'.`},
+		{`(a b) +(c d)`, `Documents containing 'c' or containing 'd' (those containing 'a' or containing 'b' rank higher).`},
+		{`+(a b) +(c d)`, `Documents (containing 'a' or containing 'b') and (containing 'c' or containing 'd').`},
+		{`a NOT b`, "Documents containing 'a' or not containing 'b'.\nNote: `NOT b` is one of the alternatives rather than an exclusion, so it matches anything without b - use -b to exclude"},
+		{`alice OR -bob`, "Documents containing 'alice' or not containing 'bob'.\nNote: `-bob` is part of an OR expression, so it's treated as NOT bob"},
+		{`a AND +b`, "Documents containing 'a' and containing 'b'.\nNote: `+b` is part of an AND expression, so the + has no effect"},
+		{`NOT -b`, "Documents containing 'b'.\nNote: `NOT -b` is a double negative, so it matches b"},
+	}
+	for _, dat := range data {
+		got, err := Explain(dat.input)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		if got != dat.expected {
+			t.Errorf("`%s`:\nexpected %q\n     got %q", dat.input, dat.expected, got)
+		}
+	}
+
+	// the default operator changes everything
+	p := Parser{DefaultOp: AND}
+	got, err := p.Explain(`lemon lime`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `Documents containing 'lemon' and containing 'lime'.`; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	for _, bad := range []string{`(a`, `a:(b:c)`, `n:[x TO y]`} {
+		if _, err := Explain(bad); err == nil {
+			t.Errorf("`%s`: expected error", bad)
+		}
+	}
+}
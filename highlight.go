@@ -0,0 +1,251 @@
+package qs
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Class is the syntax highlighting category of a token.
+type Class int
+
+const (
+	ClassNone     Class = iota // whitespace
+	ClassTerm                  // plain terms
+	ClassPhrase                // quoted phrases
+	ClassField                 // field names, including the colon
+	ClassOperator              // AND, OR, NOT, + and -
+	ClassParen                 // grouping parentheses
+	ClassRange                 // ranges and relational comparisons
+	ClassBoost                 // ^ boost suffixes
+	ClassFuzzy                 // ~ fuzziness suffixes
+	ClassError                 // input the lexer couldn't handle
+)
+
+var classNames = [...]string{
+	ClassNone:     "",
+	ClassTerm:     "term",
+	ClassPhrase:   "phrase",
+	ClassField:    "field",
+	ClassOperator: "operator",
+	ClassParen:    "paren",
+	ClassRange:    "range",
+	ClassBoost:    "boost",
+	ClassFuzzy:    "fuzzy",
+	ClassError:    "error",
+}
+
+// String returns the name of the class, as used in CSS class names.
+func (c Class) String() string {
+	if c < 0 || int(c) >= len(classNames) {
+		return "unknown"
+	}
+	return classNames[c]
+}
+
+// Classify assigns a highlighting class to each of the tokens returned by
+// Tokenize. Unlike the token kinds, the classes take some context into
+// account, so field names and range endpoints are distinguished from plain
+// terms.
+func Classify(toks []Token) []Class {
+	classes := make([]Class, len(toks))
+	inRange := false
+	relational := false
+	for i, tok := range toks {
+		c := ClassNone
+		// a relational operator only applies to the very next token
+		afterRelational := relational
+		if tok.Kind != TokSpace {
+			relational = false
+		}
+		switch tok.Kind {
+		case TokError:
+			c = ClassError
+		case TokLiteral, TokQuoted:
+			switch {
+			case inRange || afterRelational:
+				c = ClassRange
+			case tok.Kind == TokLiteral && i+1 < len(toks) && toks[i+1].Kind == TokColon:
+				c = ClassField
+			case tok.Kind == TokQuoted:
+				c = ClassPhrase
+			default:
+				c = ClassTerm
+			}
+		case TokColon:
+			c = ClassField
+		case TokOr, TokAnd, TokNot, TokPlus, TokMinus:
+			c = ClassOperator
+		case TokLParen, TokRParen:
+			c = ClassParen
+		case TokLSquare, TokLBrace:
+			inRange = true
+			c = ClassRange
+		case TokRSquare, TokRBrace:
+			inRange = false
+			c = ClassRange
+		case TokTo:
+			c = ClassRange
+		case TokGreater, TokLess, TokEqual:
+			relational = tok.Kind != TokEqual || afterRelational
+			c = ClassRange
+		case TokBoost:
+			c = ClassBoost
+		case TokFuzzy:
+			c = ClassFuzzy
+		}
+		classes[i] = c
+	}
+	return classes
+}
+
+// diagnostic returns the position and message to show for an error.
+// Problems inside macros are shown at the macro reference.
+func diagnostic(err error) (ParseError, bool) {
+	switch e := err.(type) {
+	case ParseError:
+		return e, true
+	case MacroError:
+		return ParseError{e.Pos, fmt.Sprintf("in macro @%s: %s", e.Name, e.Err)}, true
+	}
+	return ParseError{}, false
+}
+
+// errorSpan works out which tokens are covered by a ParseError.
+// Returns the index of the first token and one past the last, or -1,-1 if
+// the error doesn't point at any token (eg it's at the end of the input).
+func errorSpan(toks []Token, err error) (int, int) {
+	pe, ok := diagnostic(err)
+	if !ok {
+		return -1, -1
+	}
+	first := -1
+	for i, tok := range toks {
+		if tok.Kind != TokSpace && tok.Pos <= pe.Pos && pe.Pos < tok.End {
+			first = i
+			break
+		}
+		if tok.Kind != TokSpace && tok.Pos > pe.Pos {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		return -1, -1
+	}
+	last := first
+	switch toks[first].Kind {
+	case TokLSquare, TokLBrace:
+		// the whole range is at fault
+		for j := first + 1; j < len(toks); j++ {
+			last = j
+			if k := toks[j].Kind; k == TokRSquare || k == TokRBrace {
+				break
+			}
+		}
+	case TokLiteral:
+		// include the colon of a field name
+		if first+1 < len(toks) && toks[first+1].Kind == TokColon {
+			last = first + 1
+		}
+	}
+	return first, last + 1
+}
+
+// HTML renders a query string as HTML for display, with each token wrapped
+// in a <span> with a CSS class of "qs-" followed by its Class name, eg:
+//
+//   <span class="qs-field">tags</span><span class="qs-field">:</span><span class="qs-term">citrus</span>
+//
+// If err is a ParseError (or MacroError), the offending section of the query
+// is further wrapped in a <mark class="qs-diag">, with the error message as
+// its title.
+// An error at the very end of the input produces an empty <mark>, which can
+// be made visible using CSS.
+func HTML(q string, err error) string {
+	toks := Tokenize(q)
+	classes := Classify(toks)
+	diagStart, diagEnd := errorSpan(toks, err)
+
+	var sb strings.Builder
+	openDiag := func() {
+		sb.WriteString(`<mark class="qs-diag" title="`)
+		pe, _ := diagnostic(err)
+		sb.WriteString(html.EscapeString(pe.Msg))
+		sb.WriteString(`">`)
+	}
+	for i, tok := range toks {
+		if i == diagStart {
+			openDiag()
+		}
+		if classes[i] == ClassNone {
+			sb.WriteString(html.EscapeString(tok.Text))
+		} else {
+			sb.WriteString(`<span class="qs-`)
+			sb.WriteString(classes[i].String())
+			if tok.Err != "" {
+				sb.WriteString(`" title="`)
+				sb.WriteString(html.EscapeString(tok.Err))
+			}
+			sb.WriteString(`">`)
+			sb.WriteString(html.EscapeString(tok.Text))
+			sb.WriteString(`</span>`)
+		}
+		if i == diagEnd-1 {
+			sb.WriteString(`</mark>`)
+		}
+	}
+	if _, ok := diagnostic(err); ok && diagStart == -1 {
+		openDiag()
+		sb.WriteString(`</mark>`)
+	}
+	return sb.String()
+}
+
+// ANSI escape sequences used by ANSI(), indexed by Class
+var ansiColours = [...]string{
+	ClassNone:     "",
+	ClassTerm:     "",
+	ClassPhrase:   "32",   // green
+	ClassField:    "36",   // cyan
+	ClassOperator: "1;35", // bold magenta
+	ClassParen:    "1",    // bold
+	ClassRange:    "34",   // blue
+	ClassBoost:    "33",   // yellow
+	ClassFuzzy:    "33",   // yellow
+	ClassError:    "31",   // red
+}
+
+// ansiDiag is used for the section of the query at fault in a ParseError
+const ansiDiag = "1;4;31;103" // bold, underlined red on yellow
+
+// ANSI renders a query string with ANSI colour escapes, for display on a
+// terminal.
+//
+// If err is a ParseError (or MacroError), the offending section of the query
+// is shown underlined in red on yellow. An error at the very end of the input is
+// marked by an extra highlighted space.
+func ANSI(q string, err error) string {
+	toks := Tokenize(q)
+	classes := Classify(toks)
+	diagStart, diagEnd := errorSpan(toks, err)
+
+	var sb strings.Builder
+	for i, tok := range toks {
+		sgr := ansiColours[classes[i]]
+		if i >= diagStart && i < diagEnd {
+			sgr = ansiDiag
+		}
+		if sgr == "" {
+			sb.WriteString(tok.Text)
+			continue
+		}
+		sb.WriteString("\x1b[" + sgr + "m")
+		sb.WriteString(tok.Text)
+		sb.WriteString("\x1b[0m")
+	}
+	if _, ok := diagnostic(err); ok && diagStart == -1 {
+		sb.WriteString("\x1b[" + ansiDiag + "m \x1b[0m")
+	}
+	return sb.String()
+}
@@ -0,0 +1,74 @@
+package qs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`tags:citrus`, "field field term"},
+		{`-"navel orange"^2`, "operator phrase boost"},
+		{`a AND NOT b~`, "term _ operator _ operator _ term fuzzy"},
+		{`date:[2015-01-01 TO 2015-02-01}`, "field field range range _ range _ range range"},
+		{`n:>=10 foo`, "field field range range range _ term"},
+		{`(x) "oops`, "paren term paren _ error"},
+	}
+
+	for _, dat := range data {
+		toks := Tokenize(dat.input)
+		got := []string{}
+		for _, c := range Classify(toks) {
+			name := c.String()
+			if name == "" {
+				name = "_"
+			}
+			got = append(got, name)
+		}
+		if strings.Join(got, " ") != dat.expected {
+			t.Errorf("Expected `%s`, got `%s`: for `%s`", dat.expected, strings.Join(got, " "), dat.input)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	got := HTML(`tags:a<b&c "x"`, nil)
+	expected := `<span class="qs-field">tags</span><span class="qs-field">:</span><span class="qs-term">a&lt;b&amp;c</span> <span class="qs-phrase">&#34;x&#34;</span>`
+	if got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	q := `foo AND date:[2015-01-01 TO wibble]`
+	_, err := Parse(q)
+	got = HTML(q, err)
+	expected = `<span class="qs-term">foo</span> <span class="qs-operator">AND</span> <span class="qs-field">date</span><span class="qs-field">:</span>` +
+		`<mark class="qs-diag" title="not numeric"><span class="qs-range">[</span><span class="qs-range">2015-01-01</span> <span class="qs-range">TO</span> <span class="qs-range">wibble</span><span class="qs-range">]</span></mark>`
+	if got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	q = `(foo`
+	_, err = Parse(q)
+	got = HTML(q, err)
+	if !strings.HasSuffix(got, `<mark class="qs-diag" title="missing )"></mark>`) {
+		t.Errorf("missing end-of-input marker: %s", got)
+	}
+}
+
+func TestANSI(t *testing.T) {
+	got := ANSI(`a:b`, nil)
+	expected := "\x1b[36ma\x1b[0m\x1b[36m:\x1b[0mb"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	q := `foo bar:(baz:wibble)`
+	_, err := Parse(q)
+	got = ANSI(q, err)
+	if !strings.Contains(got, "\x1b["+ansiDiag+"mbaz\x1b[0m\x1b["+ansiDiag+"m:\x1b[0m") {
+		t.Errorf("error not marked: %q", got)
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"unicode/utf8"
 )
 
-// TODO: handle escaping special characters:
+// TODO: handle escaping special characters outside of quotes
 
 type tokType int
 
@@ -35,33 +35,36 @@ const (
 	tFUZZY
 )
 
-func (t tokType) String() string {
+var tokTypeNames = [...]string{
+	tEOF:     "tEOF",
+	tERROR:   "tERROR",
+	tLITERAL: "tLITERAL",
+	tQUOTED:  "tQUOTED",
+	tOR:      "tOR",
+	tAND:     "tAND",
+	tNOT:     "tNOT",
+	tTO:      "tTO",
+	tPLUS:    "tPLUS",
+	tMINUS:   "tMINUS",
+	tCOLON:   "tCOLON",
+	tEQUAL:   "tEQUAL",
+	tGREATER: "tGREATER",
+	tLESS:    "tLESS",
+	tLPAREN:  "tLPAREN",
+	tRPAREN:  "tRPAREN",
+	tLSQUARE: "tLSQUARE",
+	tRSQUARE: "tRSQUARE",
+	tLBRACE:  "tLBRACE",
+	tRBRACE:  "tRBRACE",
+	tBOOST:   "tBOOST",
+	tFUZZY:   "tFUZZY",
+}
 
-	tokTypes := map[tokType]string{
-		tEOF:     "tEOF",
-		tERROR:   "tERROR",
-		tLITERAL: "tLITERAL",
-		tQUOTED:  "tQUOTED",
-		tOR:      "tOR",
-		tAND:     "tAND",
-		tNOT:     "tNOT",
-		tTO:      "tTO",
-		tPLUS:    "tPLUS",
-		tMINUS:   "tMINUS",
-		tCOLON:   "tCOLON",
-		tEQUAL:   "tEQUAL",
-		tGREATER: "tGREATER",
-		tLESS:    "tLESS",
-		tLPAREN:  "tLPAREN",
-		tRPAREN:  "tRPAREN",
-		tLSQUARE: "tLSQUARE",
-		tRSQUARE: "tRSQUARE",
-		tLBRACE:  "tLBRACE",
-		tRBRACE:  "tRBRACE",
-		tBOOST:   "tBOOST",
-		tFUZZY:   "tFUZZY",
+func (t tokType) String() string {
+	if t < 0 || int(t) >= len(tokTypeNames) {
+		return ""
 	}
-	return tokTypes[t]
+	return tokTypeNames[t]
 }
 
 // some single-rune tokens
@@ -84,31 +87,62 @@ type token struct {
 	typ tokType
 	val string
 	pos int
+	end int // byte offset just past the token in the input
 }
 
 type stateFn func(*lexer) stateFn
 
+// maxLookahead is the number of tokens the parser needs to see ahead
+const maxLookahead = 2
+
+// lexer is a pull-based scanner: each call to nextToken runs the state
+// machine just far enough to produce one more token, so nothing is
+// allocated however long the input.
 type lexer struct {
 	input   string
-	tokens  []token
+	state   stateFn
+	tok     token // the token emitted by the last state
+	ready   bool  // set if tok hasn't been collected yet
 	pos     int
 	prevpos int
 	start   int
 }
 
+func (l *lexer) init(input string) {
+	*l = lexer{input: input, state: lexDefault}
+}
+
+// nextToken returns the next token in the input. After the final token
+// (a tEOF, or a tERROR for an unrecoverable error), it returns tEOF
+// forever.
+func (l *lexer) nextToken() token {
+	// run state machine - each state emits at most one token and returns
+	// the next state, or nil when finished
+	for !l.ready {
+		if l.state == nil {
+			return token{typ: tEOF}
+		}
+		l.state = l.state(l)
+	}
+	l.ready = false
+	return l.tok
+}
+
 // lex takes an input string and breaks it up into an array of tokens.
 // The last token will be an tEOF, unless an error occurs, in which case
 // it will be a tERROR.
 func lex(input string) []token {
-	l := &lexer{
-		input:  input,
-		tokens: []token{},
-	}
-	// run state machine - each state returns the next state, or nil when finished
-	for state := lexDefault; state != nil; {
-		state = state(l)
+	var l lexer
+	l.init(input)
+	// rough guess, to avoid regrowing for long queries
+	tokens := make([]token, 0, len(input)/4+2)
+	for {
+		tok := l.nextToken()
+		tokens = append(tokens, tok)
+		if tok.typ == tEOF || (l.state == nil && !l.ready) {
+			return tokens
+		}
 	}
-	return l.tokens
 }
 
 func (l *lexer) next() rune {
@@ -137,12 +171,14 @@ func (l *lexer) peek() rune {
 }
 
 func (l *lexer) emit(t tokType) {
-	l.tokens = append(l.tokens, token{t, l.input[l.start:l.pos], l.start})
+	l.tok = token{t, l.input[l.start:l.pos], l.start, l.pos}
+	l.ready = true
 	l.start = l.pos
 }
 
 func (l *lexer) emitError(msg string) {
-	l.tokens = append(l.tokens, token{tERROR, msg, l.start})
+	l.tok = token{tERROR, msg, l.start, l.pos}
+	l.ready = true
 	l.start = l.pos
 }
 
@@ -215,6 +251,10 @@ func lexQuoted(l *lexer) stateFn {
 			return nil
 		}
 		r := l.next()
+		if r == '\\' && !l.eof() {
+			l.next() // escaped char can't close the quote
+			continue
+		}
 		if r == q {
 			break
 		}
@@ -232,12 +272,20 @@ func lexSuffix(l *lexer) stateFn {
 			break
 		}
 		r := l.next()
-		if unicode.IsSpace(r) {
+		if unicode.IsSpace(r) || r == ')' {
 			l.backup()
 			break
 		}
 		if !strings.ContainsRune("0123456789.", r) {
+			// skip the rest of the suffix
+			for !l.eof() {
+				if r := l.next(); unicode.IsSpace(r) || r == ')' {
+					l.backup()
+					break
+				}
+			}
 			l.emitError("bad number")
+			return lexDefault
 		}
 	}
 
@@ -249,3 +297,22 @@ func lexSuffix(l *lexer) stateFn {
 	}
 	return lexDefault
 }
+
+// unquote strips the quotes from a tQUOTED value and resolves escapes.
+// Only the enclosing quote character and backslash itself can be escaped -
+// any other backslash is kept as-is.
+func unquote(val string) string {
+	q := val[0]
+	s := val[1 : len(val)-1]
+	if strings.IndexByte(s, '\\') == -1 {
+		return s
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == q || s[i+1] == '\\') {
+			i++
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
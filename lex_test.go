@@ -75,6 +75,13 @@ func TestLexer(t *testing.T) {
 		{`wibble~`, []tokType{tLITERAL, tFUZZY, tEOF}},
 		{`wibble~0.1`, []tokType{tLITERAL, tFUZZY, tEOF}},
 		{`wibble^5`, []tokType{tLITERAL, tBOOST, tEOF}},
+		{`(wibble^5)`, []tokType{tLPAREN, tLITERAL, tBOOST, tRPAREN, tEOF}},
+		{`(wibble~1)`, []tokType{tLPAREN, tLITERAL, tFUZZY, tRPAREN, tEOF}},
+		{`"a \" b" c`, []tokType{tQUOTED, tLITERAL, tEOF}},
+		{`'a \' b' c`, []tokType{tQUOTED, tLITERAL, tEOF}},
+		{`"a \\" b`, []tokType{tQUOTED, tLITERAL, tEOF}},
+		{`wibble^5xyz foo`, []tokType{tLITERAL, tERROR, tLITERAL, tEOF}},
+		{`"unclosed`, []tokType{tERROR}},
 	}
 
 	for _, dat := range data {
@@ -91,3 +98,43 @@ func TestLexer(t *testing.T) {
 	}
 
 }
+
+// the streaming lexer should keep returning EOF once it's finished
+func TestLexerStream(t *testing.T) {
+	for _, in := range []string{`a b`, `"unclosed`} {
+		var l lexer
+		l.init(in)
+		n := 0
+		for l.nextToken().typ != tEOF {
+			n++
+			if n > 10 {
+				t.Fatalf("`%s`: no EOF", in)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if tok := l.nextToken(); tok.typ != tEOF {
+				t.Errorf("`%s`: expected EOF, got %s", in, tok.typ)
+			}
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`"navel orange"`, `navel orange`},
+		{`"say \"hi\""`, `say "hi"`},
+		{`'it\'s'`, `it's`},
+		{`"C:\\Windows"`, `C:\Windows`},
+		// other backslashes are literal
+		{`"C:\Windows"`, `C:\Windows`},
+		{`'say \"hi\"'`, `say \"hi\"`},
+	}
+	for _, dat := range data {
+		if got := unquote(dat.input); got != dat.expected {
+			t.Errorf("%s: expected `%s`, got `%s`", dat.input, dat.expected, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/blevesearch/bleve/search/searcher"
+	"sort"
+)
+
+// Term list lookups.
+//
+// If Parser.Lists is set, @list(name) matches any of the terms in the named
+// list, which is held outside the query (like Elasticsearch's terms lookup),
+// eg:
+//
+//   -author_id:@list(blocked_authors)
+//
+// The list is fetched when the query is compiled, so syntax trees stay small
+// however big the list is. The terms are matched exactly as given, without
+// analysis, so lookups are best suited to keyword fields such as IDs.
+
+// TermListProvider supplies the term lists used by @list(name).
+type TermListProvider interface {
+	TermList(name string) ([]string, error)
+}
+
+// TermListMap is a TermListProvider holding a fixed set of lists.
+type TermListMap map[string][]string
+
+// TermList returns the named list.
+func (m TermListMap) TermList(name string) ([]string, error) {
+	terms, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown list")
+	}
+	return terms, nil
+}
+
+// parseLookup parses @list(name). tok is the @list literal, which has
+// already been consumed.
+func (p *parseState) parseLookup(tok token) (Node, error) {
+	args, end, err := p.parseArgs(tok)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 1 || args[0] == "" {
+		return nil, ParseError{tok.pos, "expected @list(name)"}
+	}
+	return &TermsLookup{Span: Span{tok.pos, end}, Name: args[0]}, nil
+}
+
+func (p *Parser) compileLookup(l *TermsLookup, ctx context) (query.Query, error) {
+	if p.Lists == nil {
+		return nil, ParseError{l.Pos(), "term lists not available"}
+	}
+	terms, err := p.Lists.TermList(l.Name)
+	if err != nil {
+		return nil, ParseError{l.Pos(), fmt.Sprintf("list '%s': %s", l.Name, err)}
+	}
+	return setField(NewTermSetQuery(terms), ctx)
+}
+
+// TermSetQuery matches documents containing any of a set of terms.
+// It's equivalent to a disjunction of TermQuery objects, but is far cheaper
+// to build and run for large sets.
+//
+// Note that bleve's query.ParseQuery doesn't know about TermSetQuery, so
+// its JSON form can't be read back in.
+type TermSetQuery struct {
+	// Terms is sorted, with no duplicates
+	Terms    []string     `json:"terms"`
+	FieldVal string       `json:"field,omitempty"`
+	BoostVal *query.Boost `json:"boost,omitempty"`
+}
+
+// NewTermSetQuery creates a TermSetQuery matching any of terms.
+// Duplicate terms are removed.
+func NewTermSetQuery(terms []string) *TermSetQuery {
+	set := make([]string, len(terms))
+	copy(set, terms)
+	sort.Strings(set)
+	n := 0
+	for i, t := range set {
+		if i == 0 || t != set[n-1] {
+			set[n] = t
+			n++
+		}
+	}
+	return &TermSetQuery{Terms: set[:n]}
+}
+
+// has returns true if term is in the set
+func (q *TermSetQuery) has(term string) bool {
+	i := sort.SearchStrings(q.Terms, term)
+	return i < len(q.Terms) && q.Terms[i] == term
+}
+
+func (q *TermSetQuery) SetBoost(b float64) {
+	boost := query.Boost(b)
+	q.BoostVal = &boost
+}
+
+func (q *TermSetQuery) Boost() float64 {
+	return q.BoostVal.Value()
+}
+
+func (q *TermSetQuery) SetField(f string) {
+	q.FieldVal = f
+}
+
+func (q *TermSetQuery) Field() string {
+	return q.FieldVal
+}
+
+func (q *TermSetQuery) Searcher(i index.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	if len(q.Terms) == 0 {
+		return searcher.NewMatchNoneSearcher(i)
+	}
+	field := q.FieldVal
+	if q.FieldVal == "" {
+		field = m.DefaultSearchField()
+	}
+	return searcher.NewMultiTermSearcher(i, q.Terms, field, q.BoostVal.Value(), options, false)
+}
@@ -0,0 +1,188 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLookupParse(t *testing.T) {
+	p := Parser{Lists: TermListMap{}}
+	good := []string{
+		`-author_id:@list(blocked_authors)`,
+		`@list("odd name")^2`,
+		`@list(x) (y)`,
+	}
+	for _, in := range good {
+		got, err := p.ParseTree(in)
+		if err != nil {
+			t.Fatalf("`%s`: %s", in, err)
+		}
+		if Format(got) != in {
+			t.Errorf("`%s`: formatted as `%s`", in, Format(got))
+		}
+	}
+
+	bad := []string{`@list`, `@list()`, `@list(a b)`, `@list(a`, `@list (a)`}
+	for _, in := range bad {
+		if _, err := p.ParseTree(in); err == nil {
+			t.Errorf("`%s`: expected error", in)
+		}
+	}
+
+	// without a provider, it's just a term followed by a group
+	tree, err := ParseTree(`@list(x)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.(*List).Clauses) != 2 {
+		t.Errorf("expected @list to be ordinary text")
+	}
+}
+
+func TestLookupCompile(t *testing.T) {
+	p := Parser{Lists: TermListMap{"blocked": {"u3", "u1", "u2", "u1"}}}
+
+	q, err := p.Parse(`-author_id:@list(blocked)^2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := NewTermSetQuery([]string{"u1", "u2", "u3"})
+	expected.SetField("author_id")
+	expected.SetBoost(2)
+	if !reflect.DeepEqual(q, mustNot(expected)) {
+		t.Errorf("unexpected query %#v", q)
+	}
+
+	if _, err := p.Parse(`@list(nope)`); err == nil || err.Error() != `0: list 'nope': unknown list` {
+		t.Errorf("expected unknown list error, got %v", err)
+	}
+
+	// lists are resolved at compile time
+	tree, _ := p.ParseTree(`@list(blocked)`)
+	var plain Parser
+	if _, err := plain.Compile(tree); err == nil {
+		t.Errorf("expected error compiling without a provider")
+	}
+}
+
+func TestLookupSearch(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	blocked := []string{}
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("u%d", i)
+		if i%100 == 0 {
+			if err := idx.Index(id, map[string]string{"author_id": id, "body": "spam"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i%200 == 0 {
+			blocked = append(blocked, id)
+		}
+	}
+
+	p := Parser{Lists: TermListMap{"blocked": blocked, "empty": nil}}
+	search := func(in string) []string {
+		q, err := p.Parse(in)
+		if err != nil {
+			t.Fatalf("`%s`: %s", in, err)
+		}
+		res, err := idx.Search(bleve.NewSearchRequestOptions(q, 100, 0, false))
+		if err != nil {
+			t.Fatalf("`%s`: %s", in, err)
+		}
+		ids := []string{}
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if got := search(`author_id:@list(blocked)`); !reflect.DeepEqual(got, []string{"u0", "u200", "u400", "u600", "u800"}) {
+		t.Errorf("unexpected hits %v", got)
+	}
+	if got := search(`spam -author_id:@list(blocked)`); !reflect.DeepEqual(got, []string{"u100", "u300", "u500", "u700", "u900"}) {
+		t.Errorf("unexpected hits %v", got)
+	}
+	if got := search(`author_id:@list(empty)`); len(got) != 0 {
+		t.Errorf("unexpected hits %v", got)
+	}
+}
+
+// make sure TermSetQuery satisfies the bleve interfaces
+var _ query.FieldableQuery = &TermSetQuery{}
+var _ query.BoostableQuery = &TermSetQuery{}
+
+func TestTermSetKeyword(t *testing.T) {
+	m := keywordMapping()
+	idx, err := bleve.NewMemOnly(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	for _, id := range []string{"a-1", "B 2", "c3", "d4"} {
+		if err := idx.Index(id, map[string]string{"id": id, "title": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := []struct {
+		input   string
+		termSet bool
+	}{
+		{`id:(a-1 "B 2" zzz)`, true},
+		{`id:("a-1" OR c3 OR c3)`, true},
+		{`id:(a-1 c*)`, false},
+		{`id:(a-1 -c3)`, false},
+		{`id:(a-1 c3~1)`, false},
+		{`id:(a-1)`, false},
+		{`title:(a B)`, false},
+	}
+	for _, dat := range data {
+		plain := Parser{}
+		keyed := Parser{Mapping: m}
+		q1, err := plain.Parse(dat.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q2, err := keyed.Parse(dat.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := q2.(*TermSetQuery); ok != dat.termSet {
+			t.Errorf("`%s`: expected TermSetQuery=%v, got %T", dat.input, dat.termSet, q2)
+		}
+
+		// should match the same docs either way
+		hits := func(q query.Query) []string {
+			res, err := idx.Search(bleve.NewSearchRequest(q))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, hit := range res.Hits {
+				ids = append(ids, hit.ID)
+			}
+			sort.Strings(ids)
+			return ids
+		}
+		if h1, h2 := hits(q1), hits(q2); !reflect.DeepEqual(h1, h2) {
+			t.Errorf("`%s`: got %v with mapping, %v without", dat.input, h2, h1)
+		}
+	}
+
+	// AND as the default operator means the group isn't a disjunction
+	p := Parser{Mapping: m, DefaultOp: AND}
+	q, _ := p.Parse(`id:(a-1 c3)`)
+	if _, ok := q.(*TermSetQuery); ok {
+		t.Errorf("unexpected TermSetQuery with AND default")
+	}
+}
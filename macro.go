@@ -0,0 +1,158 @@
+package qs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Macros.
+//
+// If Parser.Macros is set, @name and @name(arg1 arg2 ...) are references to
+// macros. Each reference is expanded during parsing by asking the resolver
+// for the macro's query text and parsing that in turn, eg:
+//
+//   @citrus_fruits AND NOT @discontinued
+//   pubdate:@recent(7d)
+//
+// Arguments are separated by spaces, and may be quoted. Macros can refer to
+// other macros, up to Parser.MaxMacroDepth levels deep; recursive
+// references are an error.
+
+// DefaultMaxMacroDepth is the macro nesting limit used if
+// Parser.MaxMacroDepth is 0.
+const DefaultMaxMacroDepth = 10
+
+// MacroResolver supplies the definitions of macros.
+type MacroResolver interface {
+	// Macro returns the query text that a reference to the named macro
+	// expands to. args is nil if the reference has no arguments.
+	Macro(name string, args []string) (string, error)
+}
+
+// MacroFunc adapts an ordinary function to a MacroResolver.
+type MacroFunc func(name string, args []string) (string, error)
+
+// Macro calls f(name, args).
+func (f MacroFunc) Macro(name string, args []string) (string, error) {
+	return f(name, args)
+}
+
+// MacroMap is a MacroResolver for a fixed set of macros, which take no
+// arguments.
+type MacroMap map[string]string
+
+// Macro returns the definition of the named macro.
+func (m MacroMap) Macro(name string, args []string) (string, error) {
+	body, ok := m[name]
+	if !ok {
+		return "", fmt.Errorf("unknown macro")
+	}
+	if args != nil {
+		return "", fmt.Errorf("macro takes no arguments")
+	}
+	return body, nil
+}
+
+// MacroError is returned for a problem with a macro. Pos is the position
+// of the reference in the query being parsed, and Err describes the
+// problem. If the problem is within the macro's definition, Err is a
+// ParseError (or another MacroError) with a position relative to the
+// definition.
+type MacroError struct {
+	Pos  int
+	Name string
+	Err  error
+}
+
+func (e MacroError) Error() string {
+	return fmt.Sprintf("%d: in macro @%s: %s", e.Pos, e.Name, e.Err)
+}
+
+// macroName returns the name of the macro if s is a reference
+// (@ followed by letters, digits or underscores).
+func macroName(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '@' {
+		return "", false
+	}
+	for i, r := range s[1:] {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return "", false
+		}
+	}
+	return s[1:], true
+}
+
+// parseArgs parses the arguments following a macro name (or @list).
+// They must follow immediately, otherwise it's a group. Returns the
+// arguments (nil if there are no parentheses, so @a() and @a can be told
+// apart) and the end of the reference.
+func (p *parseState) parseArgs(tok token) ([]string, int, error) {
+	open := p.peek()
+	if open.typ != tLPAREN || open.pos != tok.end {
+		return nil, tok.end, nil
+	}
+	p.next()
+	args := []string{}
+	for {
+		arg := p.next()
+		switch arg.typ {
+		case tRPAREN:
+			return args, arg.end, nil
+		case tLITERAL:
+			args = append(args, arg.val)
+		case tQUOTED:
+			args = append(args, unquote(arg.val))
+		case tEOF:
+			return nil, 0, ParseError{arg.pos, "missing )"}
+		case tERROR:
+			return nil, 0, ParseError{arg.pos, arg.val}
+		default:
+			return nil, 0, ParseError{arg.pos, fmt.Sprintf("unexpected %s in arguments", arg.val)}
+		}
+	}
+}
+
+// parseMacro parses and expands a macro reference. tok is the @name
+// literal, which has already been consumed.
+//   macro = "@" name {"(" {lit} ")"}
+func (p *parseState) parseMacro(tok token, name string) (Node, error) {
+	m := &MacroExpr{Span: Span{tok.pos, tok.end}, Name: name}
+	var err error
+	m.Args, m.To, err = p.parseArgs(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	maxDepth := p.MaxMacroDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxMacroDepth
+	}
+	// a macro can refer to itself with different arguments, but the same
+	// reference again would never end
+	ref := Format(m)
+	for i, outer := range p.expanding {
+		if outer == ref {
+			chain := append(append([]string(nil), p.expanding[i:]...), ref)
+			return nil, MacroError{m.Pos(), name, fmt.Errorf("recursive reference (%s)", strings.Join(chain, " -> "))}
+		}
+	}
+	if len(p.expanding) >= maxDepth {
+		return nil, MacroError{m.Pos(), name, fmt.Errorf("macros nested too deeply (limit %d)", maxDepth)}
+	}
+
+	body, err := p.Macros.Macro(name, m.Args)
+	if err != nil {
+		return nil, MacroError{m.Pos(), name, err}
+	}
+	sub := newParseState(p.Parser, body, append(append([]string(nil), p.expanding...), ref))
+	defer sub.release()
+	x, err := sub.parseQuery()
+	if err != nil {
+		return nil, MacroError{m.Pos(), name, err}
+	}
+	m.X = x
+	return m, nil
+}
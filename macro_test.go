@@ -0,0 +1,138 @@
+package qs
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testMacros = MacroFunc(func(name string, args []string) (string, error) {
+	switch name {
+	case "citrus_fruits":
+		return `lemon OR lime OR grapefruit`, nil
+	case "discontinued":
+		return `status:discontinued`, nil
+	case "recent":
+		if len(args) != 1 {
+			return "", fmt.Errorf("expected 1 argument")
+		}
+		return `>=` + args[0], nil
+	case "either":
+		return strings.Join(args, " OR "), nil
+	case "nested":
+		return `@citrus_fruits AND @discontinued`, nil
+	case "broken":
+		return `tags:(oops`, nil
+	case "unbalanced":
+		return `tags:oops) x`, nil
+	case "loop_a":
+		return `x @loop_b`, nil
+	case "loop_b":
+		return `@loop_a`, nil
+	case "deep":
+		if len(args) == 0 {
+			return "bottom", nil
+		}
+		return fmt.Sprintf(`@deep(%s)`, strings.Join(args[1:], " ")), nil
+	case "badrange":
+		return `n:[a TO 5]`, nil
+	}
+	return "", fmt.Errorf("unknown macro")
+})
+
+func ExampleMacroMap() {
+	p := Parser{Macros: MacroMap{
+		"citrus": `lemon OR lime OR grapefruit`,
+		"stale":  `status:(discontinued OR withdrawn)`,
+	}}
+	tree, _ := p.ParseTree(`tags:@citrus AND NOT @stale`)
+	fmt.Println(Format(tree))
+	// Output: tags:@citrus AND NOT @stale
+}
+
+func TestMacroExpansion(t *testing.T) {
+	data := []struct {
+		input    string
+		expanded string
+	}{
+		{`@citrus_fruits AND NOT @discontinued`, `(lemon OR lime OR grapefruit) AND NOT status:discontinued`},
+		{`pubdate:@recent(2016-01-01)`, `pubdate:(>=2016-01-01)`},
+		{`@either(a "b c" d)^2`, `(a OR b c OR d)^2`},
+		{`@nested`, `((lemon OR lime OR grapefruit) AND status:discontinued)`},
+		{`@citrus_fruits (x)`, `(lemon OR lime OR grapefruit) (x)`},
+		{`@deep(1 2 3 4)`, `(((((bottom)))))`},
+		{`x @either()`, `x ()`},
+		{`"@citrus_fruits" foo@bar`, `"@citrus_fruits" foo@bar`},
+	}
+
+	p := Parser{Macros: testMacros}
+	for _, dat := range data {
+		tree, err := p.ParseTree(dat.input)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		if got := Format(tree); got != dat.input {
+			t.Errorf("`%s`: formatted as `%s`", dat.input, got)
+		}
+
+		// should compile the same as the hand-expanded query
+		expected, err := p.Parse(dat.expanded)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.expanded, err)
+		}
+		q, err := p.Compile(tree)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		if !reflect.DeepEqual(q, expected) {
+			t.Errorf("`%s`: expected same query as `%s`", dat.input, dat.expanded)
+		}
+	}
+
+	// without a resolver, macros are just terms
+	tree, err := ParseTree(`@citrus_fruits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tree.(*List).Clauses[0].(*Term); !ok {
+		t.Errorf("expected a plain term")
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`x @nope`, `2: in macro @nope: unknown macro`},
+		{`x @recent`, `2: in macro @recent: expected 1 argument`},
+		{`x @recent(a`, `11: missing )`},
+		{`x @recent(a:b)`, `11: unexpected : in arguments`},
+		{`x @broken`, `2: in macro @broken: 10: missing )`},
+		{`x @unbalanced`, `2: in macro @unbalanced: 9: unexpected )`},
+		{`@loop_a`, `0: in macro @loop_a: 2: in macro @loop_b: 0: in macro @loop_a: recursive reference (@loop_a -> @loop_b -> @loop_a)`},
+		{`@deep(1 2 3 4 5 6 7 8 9 10 11)`, `in macro @deep: macros nested too deeply (limit 10)`},
+		{`x @badrange`, `2: in macro @badrange: 2: not numeric`},
+	}
+
+	p := Parser{Macros: testMacros}
+	for _, dat := range data {
+		_, err := p.Parse(dat.input)
+		if err == nil {
+			t.Errorf("`%s`: expected error", dat.input)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), dat.expected) {
+			t.Errorf("`%s`: expected `%s`, got `%s`", dat.input, dat.expected, err)
+		}
+	}
+
+	p.MaxMacroDepth = 2
+	if _, err := p.Parse(`@nested`); err != nil {
+		t.Errorf("@nested should fit within depth 2: %s", err)
+	}
+	if _, err := p.Parse(`@deep(1 2)`); err == nil {
+		t.Errorf("expected depth limit error")
+	}
+}
@@ -0,0 +1,310 @@
+package qs
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/blevesearch/bleve/search/query"
+	"sort"
+)
+
+// Normalize simplifies a bleve query into a standard form, matching the
+// same documents:
+//
+//   - nested conjunctions and disjunctions are flattened
+//   - single-clause conjunctions and disjunctions are unwrapped
+//   - duplicate clauses are removed, and the rest sorted
+//   - NOT NOT x becomes x
+//   - negations within a conjunction are gathered into a single
+//     BooleanQuery (eg a AND NOT b becomes +a -b)
+//   - match-all and match-none clauses are folded away
+//   - disjunctions of term sets on the same field are merged
+//   - ranges on the same field are merged, and contradictory ones
+//     fold to match-none (see mergeRanges)
+//
+// Clauses with a boost are kept separate. Scores may differ from those of
+// the original query, as bleve scores partly on the shape of the tree.
+//
+// Two queries which normalize to the same thing are equivalent, but the
+// reverse isn't always true. q itself is left unchanged.
+func Normalize(q query.Query) query.Query {
+	if q == nil {
+		return nil
+	}
+	return normalize(copyQuery(q))
+}
+
+func normalize(q query.Query) query.Query {
+	switch q := q.(type) {
+	case *query.ConjunctionQuery:
+		if len(q.Conjuncts) == 0 {
+			return query.NewMatchNoneQuery()
+		}
+		var c clauses
+		for _, sub := range q.Conjuncts {
+			c.addMust(normalize(sub))
+		}
+		return c.build(q.BoostVal)
+	case *query.DisjunctionQuery:
+		if q.Min > 1 {
+			// not a plain disjunction, so only the clauses can be simplified
+			for i, sub := range q.Disjuncts {
+				q.Disjuncts[i] = normalize(sub)
+			}
+			return q
+		}
+		return normalizeOr(q.Disjuncts, q.BoostVal)
+	case *query.BooleanQuery:
+		return normalizeBool(q)
+	}
+	return q
+}
+
+// normalizeOr simplifies a disjunction of qs
+func normalizeOr(qs []query.Query, boost *query.Boost) query.Query {
+	var out []query.Query
+	var add func(q query.Query) bool
+	add = func(q query.Query) bool {
+		switch sub := q.(type) {
+		case *query.MatchNoneQuery:
+			return true
+		case *query.MatchAllQuery:
+			if !boosted(sub) {
+				return false
+			}
+		case *query.DisjunctionQuery:
+			if !boosted(sub) && sub.Min <= 1 {
+				for _, x := range sub.Disjuncts {
+					if !add(x) {
+						return false
+					}
+				}
+				return true
+			}
+		case *TermSetQuery:
+			// merge into an earlier set on the same field
+			if !boosted(sub) {
+				for i, x := range out {
+					if set, ok := x.(*TermSetQuery); ok && !boosted(set) && set.FieldVal == sub.FieldVal {
+						merged := NewTermSetQuery(append(append([]string(nil), set.Terms...), sub.Terms...))
+						merged.SetField(sub.FieldVal)
+						out[i] = merged
+						return true
+					}
+				}
+			}
+		}
+		out = append(out, q)
+		return true
+	}
+	for _, q := range qs {
+		if !add(normalize(q)) {
+			// something matches everything
+			return withBoost(query.NewMatchAllQuery(), boost)
+		}
+	}
+
+	out, _ = mergeRanges(out, false)
+	out = dedupe(out)
+	switch len(out) {
+	case 0:
+		return query.NewMatchNoneQuery()
+	case 1:
+		return withBoost(out[0], boost)
+	}
+	d := query.NewDisjunctionQuery(out)
+	d.BoostVal = boost
+	return d
+}
+
+func normalizeBool(b *query.BooleanQuery) query.Query {
+	if b.Must == nil && b.Should == nil && b.MustNot == nil {
+		return query.NewMatchNoneQuery()
+	}
+	var c clauses
+	if b.Must != nil {
+		c.addMust(normalize(b.Must))
+	}
+	if b.MustNot != nil {
+		c.addMustNot(normalize(b.MustNot))
+	}
+	if b.Should != nil {
+		min := 0.0
+		if d, ok := b.Should.(*query.DisjunctionQuery); ok {
+			min = d.Min
+		}
+		should := normalize(b.Should)
+		if b.Must == nil || min > 0 {
+			// without any musts (or with a minimum), shoulds are required
+			c.addMust(should)
+		} else if _, none := should.(*query.MatchNoneQuery); !none {
+			// optional, so only affects the score
+			c.should = should
+		}
+	}
+	return c.build(b.BoostVal)
+}
+
+// clauses gathers up the parts of a conjunction
+type clauses struct {
+	must    []query.Query
+	should  query.Query
+	mustNot []query.Query
+	none    bool // a must clause matches nothing
+}
+
+func (c *clauses) addMust(q query.Query) {
+	switch sub := q.(type) {
+	case *query.MatchNoneQuery:
+		c.none = true
+		return
+	case *query.MatchAllQuery:
+		if !boosted(sub) {
+			return
+		}
+	case *query.ConjunctionQuery:
+		if !boosted(sub) {
+			for _, x := range sub.Conjuncts {
+				c.addMust(x)
+			}
+			return
+		}
+	case *query.BooleanQuery:
+		if !boosted(sub) && sub.Should == nil {
+			if sub.Must != nil {
+				c.addMust(sub.Must)
+			}
+			if sub.MustNot != nil {
+				c.addMustNot(sub.MustNot)
+			}
+			return
+		}
+	}
+	c.must = append(c.must, q)
+}
+
+func (c *clauses) addMustNot(q query.Query) {
+	switch sub := q.(type) {
+	case *query.MatchNoneQuery:
+		return
+	case *query.MatchAllQuery:
+		c.none = true
+		return
+	case *query.DisjunctionQuery:
+		if !boosted(sub) && sub.Min <= 1 {
+			for _, x := range sub.Disjuncts {
+				c.addMustNot(x)
+			}
+			return
+		}
+	case *query.BooleanQuery:
+		// NOT NOT x => x
+		if !boosted(sub) && sub.Must == nil && sub.Should == nil {
+			c.addMust(normalize(sub.MustNot))
+			return
+		}
+	}
+	c.mustNot = append(c.mustNot, q)
+}
+
+// build returns the simplest query for the gathered clauses.
+// With no clauses at all, everything matches.
+func (c *clauses) build(boost *query.Boost) query.Query {
+	if c.none {
+		return query.NewMatchNoneQuery()
+	}
+	must, ok := mergeRanges(c.must, true)
+	if !ok {
+		return query.NewMatchNoneQuery()
+	}
+	must = dedupe(must)
+	// NOT a AND NOT b => NOT (a OR b)
+	mustNot, _ := mergeRanges(c.mustNot, false)
+	mustNot = dedupe(mustNot)
+
+	// x AND NOT x
+	for _, x := range must {
+		for _, y := range mustNot {
+			if queryKey(x) == queryKey(y) {
+				return query.NewMatchNoneQuery()
+			}
+		}
+	}
+
+	if len(mustNot) == 0 && c.should == nil {
+		switch len(must) {
+		case 0:
+			return withBoost(query.NewMatchAllQuery(), boost)
+		case 1:
+			return withBoost(must[0], boost)
+		}
+		q := query.NewConjunctionQuery(must)
+		q.BoostVal = boost
+		return q
+	}
+
+	if len(must) == 0 && c.should != nil {
+		// without a must, bleve would make the shoulds required
+		must = []query.Query{query.NewMatchAllQuery()}
+	}
+	q := query.NewBooleanQuery(must, nil, mustNot)
+	if d, ok := c.should.(*query.DisjunctionQuery); ok && !boosted(d) {
+		q.Should = d
+	} else if c.should != nil {
+		q.AddShould(c.should)
+	}
+	q.BoostVal = boost
+	return q
+}
+
+// boosted returns true if q has a boost other than 1
+func boosted(q query.Query) bool {
+	b, ok := q.(query.BoostableQuery)
+	return ok && b.Boost() != 1
+}
+
+// withBoost applies a boost to q. If q already has its own boost, it's
+// wrapped to keep both.
+func withBoost(q query.Query, boost *query.Boost) query.Query {
+	if boost == nil || boost.Value() == 1 {
+		return q
+	}
+	if b, ok := q.(query.BoostableQuery); ok && !boosted(q) {
+		b.SetBoost(boost.Value())
+		return q
+	}
+	c := query.NewConjunctionQuery([]query.Query{q})
+	c.BoostVal = boost
+	return c
+}
+
+// dedupe sorts queries into a standard order, dropping duplicates
+func dedupe(qs []query.Query) []query.Query {
+	if len(qs) < 2 {
+		return qs
+	}
+	type keyed struct {
+		key string
+		q   query.Query
+	}
+	sorted := make([]keyed, len(qs))
+	for i, q := range qs {
+		sorted[i] = keyed{queryKey(q), q}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+	out := []query.Query{sorted[0].q}
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].key != sorted[i-1].key {
+			out = append(out, sorted[i].q)
+		}
+	}
+	return out
+}
+
+// queryKey returns a string which is the same for identical queries
+func queryKey(q query.Query) string {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Sprintf("%T%+v", q, q)
+	}
+	return fmt.Sprintf("%T%s", q, data)
+}
@@ -0,0 +1,135 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`a AND (b AND c)`, `a AND b AND c`},
+		{`(a OR b) OR (c OR (d OR a))`, `a OR b OR c OR d`},
+		{`NOT (NOT a)`, `a`},
+		{`NOT (NOT a OR NOT b)`, `a AND b`},
+		{`a AND -b AND -c`, `+a -b -c`},
+		{`a AND NOT b`, `a -b`},
+		{`(a) AND ((a))`, `a`},
+		{`x:b x:a`, `x:a OR x:b`},
+		{`a AND NOT a`, `@none`},
+		{`a^2 AND (b AND c)`, `c AND b AND a^2`},
+		{`(a AND b)^2 AND c`, `c AND (a AND b)^2`},
+		{`+a +(b c) -d`, `+a +(b OR c) -d`},
+		{`id:(1 2) OR y:3 OR id:(2 4)`, `id:(1 2 4) OR y:3`},
+	}
+
+	p := Parser{Mapping: keywordMapping()}
+	for _, dat := range data {
+		q, err := p.Parse(dat.input)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		var expected query.Query = query.NewMatchNoneQuery()
+		if dat.expected != "@none" {
+			if expected, err = p.Parse(dat.expected); err != nil {
+				t.Fatalf("`%s`: %s", dat.expected, err)
+			}
+			expected = Normalize(expected)
+		}
+		before := queryKey(q)
+		got := Normalize(q)
+		if queryKey(got) != queryKey(expected) {
+			t.Errorf("`%s`: got %s, expected %s", dat.input, queryKey(got), queryKey(expected))
+		}
+		if queryKey(q) != before {
+			t.Errorf("`%s`: original query was modified", dat.input)
+		}
+		if again := Normalize(got); !reflect.DeepEqual(again, got) {
+			t.Errorf("`%s`: normalizing twice gave %s", dat.input, queryKey(again))
+		}
+	}
+}
+
+func TestNormalizeShape(t *testing.T) {
+	q, _ := Parse(`NOT (NOT a)`)
+	if _, ok := Normalize(q).(*query.MatchPhraseQuery); !ok {
+		t.Errorf("NOT NOT: got %s", queryKey(Normalize(q)))
+	}
+
+	q, _ = Parse(`a AND (b AND (c AND d))`)
+	if c, ok := Normalize(q).(*query.ConjunctionQuery); !ok || len(c.Conjuncts) != 4 {
+		t.Errorf("nested AND: got %s", queryKey(Normalize(q)))
+	}
+
+	q, _ = Parse(`a AND -b`)
+	b, ok := Normalize(q).(*query.BooleanQuery)
+	if !ok || b.Should != nil || len(b.Must.(*query.ConjunctionQuery).Conjuncts) != 1 || len(b.MustNot.(*query.DisjunctionQuery).Disjuncts) != 1 {
+		t.Errorf("AND NOT: got %s", queryKey(Normalize(q)))
+	}
+
+	if Normalize(nil) != nil {
+		t.Errorf("expected nil")
+	}
+}
+
+// normalized queries should find the same documents
+func TestNormalizeSearch(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	docs := map[string]string{
+		"1": "lemon lime",
+		"2": "lemon orange",
+		"3": "grapefruit",
+		"4": "lime orange grapefruit",
+		"5": "kumquat",
+	}
+	for id, body := range docs {
+		if err := idx.Index(id, map[string]string{"body": body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hits := func(q query.Query) []string {
+		res, err := idx.Search(bleve.NewSearchRequestOptions(q, 10, 0, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	inputs := []string{
+		`lemon AND (lime OR orange)`,
+		`NOT (NOT lemon)`,
+		`NOT (NOT lemon OR NOT lime)`,
+		`lime -orange -grapefruit`,
+		`(lemon OR lime) AND NOT (orange AND NOT grapefruit)`,
+		`+lemon lime`,
+		`lemon AND NOT lemon`,
+		`(lime OR lime) AND (lime OR grapefruit)`,
+		`-kumquat`,
+		`NOT (lemon OR lime)`,
+		`+(-kumquat) lemon`,
+		`+(-lime) -(-orange) lemon`,
+	}
+	for _, in := range inputs {
+		q, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h1, h2 := hits(q), hits(Normalize(q)); !reflect.DeepEqual(h1, h2) {
+			t.Errorf("`%s`: got %v normalized, %v originally", in, h2, h1)
+		}
+	}
+}
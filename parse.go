@@ -2,10 +2,11 @@ package qs
 
 import (
 	"fmt"
-	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/bleve/search/query"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,9 +27,11 @@ const (
 	AND        = 1
 )
 
+// Parser turns query strings into bleve queries, using its settings.
+//
+// A Parser holds only configuration, so once set up it can be shared by
+// multiple goroutines. Use Validate to check the configuration.
 type Parser struct {
-	tokens []token
-	pos    int
 	// DefaultOp is used when no explict OR or AND is present
 	// ie: foo bar => foo OR bar | foo AND bar
 	// TODO: not sure AND/OR is the right terminology (but it's what others use)
@@ -38,14 +41,65 @@ type Parser struct {
 	// Loc is the location to use for parsing dates in range queries.
 	// If nil, UTC is assumed.
 	Loc *time.Location
+
+	// Macros, if set, is used to expand @name macro references.
+	// If nil, @name is just an ordinary term.
+	Macros MacroResolver
+
+	// Mapping, if set, is used to find keyword fields, where a group of
+	// plain values (eg id:(1 2 3)) can be compiled into a compact
+	// TermSetQuery.
+	Mapping mapping.IndexMapping
+
+	// Lists, if set, supplies the term lists for @list(name) lookups.
+	// It takes precedence over Macros for @list.
+	Lists TermListProvider
+
+	// MaxMacroDepth limits how deeply macros can be nested.
+	// If 0, DefaultMaxMacroDepth is used.
+	MaxMacroDepth int
+
+	// Cache, if set, holds recently parsed queries for Parse to reuse.
+	Cache *QueryCache
 }
 
-// context is used to hold settings active within a given scope during parsing
-// (TODO: maybe this should just be absorbed back into the Parser struct instead?)
-type context struct {
-	// field is the name of the field currently in scope (or "")
-	field    string
-	fieldPos int
+// Validate checks the Parser's configuration, so problems can be caught
+// once up front rather than showing up as odd query results later.
+func (p *Parser) Validate() error {
+	if p.DefaultOp != OR && p.DefaultOp != AND {
+		return fmt.Errorf("invalid DefaultOp (%d)", p.DefaultOp)
+	}
+	if v, ok := p.Mapping.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("invalid Mapping: %s", err)
+		}
+	}
+	if p.MaxMacroDepth < 0 {
+		return fmt.Errorf("invalid MaxMacroDepth (%d)", p.MaxMacroDepth)
+	}
+	if p.MaxMacroDepth != 0 && p.Macros == nil {
+		return fmt.Errorf("MaxMacroDepth set, but no Macros")
+	}
+	if m, ok := p.Macros.(MacroMap); ok && p.Lists != nil {
+		if _, clash := m["list"]; clash {
+			return fmt.Errorf("macro @list is hidden by Lists")
+		}
+	}
+	return nil
+}
+
+// parseState holds the state of a single parse. They're pooled, so
+// parsing a short query allocates little beyond the syntax tree itself.
+type parseState struct {
+	*Parser
+	lex lexer
+	// tokens read from lex but not yet consumed
+	look  [maxLookahead]token
+	nlook int
+	// scratch space for gathering clauses and operands
+	scratch []Node
+	// macro references currently being expanded, outermost first
+	expanding []string
 }
 
 // Parse takes a query string and turns it into a bleve Query.
@@ -54,13 +108,16 @@ type context struct {
 // of the offending part of the input string.
 //
 // BNF(ish) query syntax:
+//   query = exprList EOF
 //   exprList = expr1*
 //   expr1 = expr2 {"OR" expr2}
 //   expr2 = expr3 {"AND" expr3}
 //   expr3 = {"NOT"} expr4
 //   expr4 = {("+"|"-")} expr5
 //   expr5 = {field} part {boost}
-//   part = lit {"~" number} | range | "(" exprList ")"
+//   part = lit {"~" number} | range | "(" exprList ")" | macro | lookup
+//   macro = "@" name {"(" {lit} ")"}
+//   lookup = "@list(" lit ")"
 //   field = lit ":"
 //   range = ("["|"}") {lit} "TO" {lit} ("]"|"}")
 //   relational = ("<"|">"|"<="|">=") lit
@@ -68,9 +125,35 @@ type context struct {
 //
 // (where lit is a string, quoted string or number)
 func (p *Parser) Parse(q string) (query.Query, error) {
-	p.tokens = lex(q)
-	ctx := context{field: ""}
-	return p.parseExprList(ctx)
+	if p.Cache != nil {
+		return p.Cache.parse(p, q)
+	}
+	return p.parse(q)
+}
+
+func (p *Parser) parse(q string) (query.Query, error) {
+	tree, err := p.ParseTree(q)
+	if err != nil {
+		return nil, err
+	}
+	return p.Compile(tree)
+}
+
+// ParseTree parses a query string into a syntax tree, without compiling it
+// into a bleve Query. The root of the returned tree is always a *List.
+//
+// Returned errors are type ParseError, or MacroError if a macro can't be
+// expanded. Problems with the meaning rather than
+// the syntax of the query (bad range values, clashing fields) aren't
+// detected until the tree is compiled.
+func (p *Parser) ParseTree(q string) (Node, error) {
+	s := newParseState(p, q, nil)
+	defer s.release()
+	l, err := s.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
 }
 
 // Parse takes a query string and turns it into a bleve Query using
@@ -82,43 +165,100 @@ func Parse(q string) (query.Query, error) {
 	return p.Parse(q)
 }
 
+// ParseTree parses a query string into a syntax tree using the default
+// Parser.
+func ParseTree(q string) (Node, error) {
+	p := Parser{DefaultOp: OR}
+	return p.ParseTree(q)
+}
+
+var statePool = sync.Pool{
+	New: func() interface{} { return &parseState{} },
+}
+
+// newParseState gets a parseState from the pool, ready to parse q.
+func newParseState(p *Parser, q string, expanding []string) *parseState {
+	s := statePool.Get().(*parseState)
+	s.Parser = p
+	s.lex.init(q)
+	s.expanding = expanding
+	return s
+}
+
+// release returns the parseState to the pool.
+func (p *parseState) release() {
+	// don't keep the query or tree alive
+	for i := range p.scratch {
+		p.scratch[i] = nil
+	}
+	scratch := p.scratch[:0]
+	if cap(scratch) > 1024 {
+		scratch = nil
+	}
+	*p = parseState{scratch: scratch}
+	statePool.Put(p)
+}
+
+// collect returns a copy of the nodes gathered in the scratch space since
+// mark, and removes them from it.
+func (p *parseState) collect(mark int) []Node {
+	nodes := p.scratch[mark:]
+	if len(nodes) == 0 {
+		return nil
+	}
+	out := make([]Node, len(nodes))
+	copy(out, nodes)
+	for i := range nodes {
+		nodes[i] = nil
+	}
+	p.scratch = p.scratch[:mark]
+	return out
+}
+
 // peek looks at the next token without consuming it.
 // peeks beyond the end of the token stream will return EOF
-func (p *Parser) peek() token {
-	if p.pos < len(p.tokens) {
-		tok := p.tokens[p.pos]
-		return tok
-	}
-	return token{typ: tEOF}
+func (p *parseState) peek() token {
+	return p.peekN(0)
 }
 
-// backup steps back one position in the token stream
-func (p *Parser) backup() {
-	p.pos -= 1
+// peekN looks i tokens ahead (up to maxLookahead-1) without consuming
+// anything.
+func (p *parseState) peekN(i int) token {
+	for p.nlook <= i {
+		p.look[p.nlook] = p.lex.nextToken()
+		p.nlook++
+	}
+	return p.look[i]
 }
 
 // next fetches the next token in the stream
-func (p *Parser) next() token {
-	if p.pos < len(p.tokens) {
-		tok := p.tokens[p.pos]
-		p.pos += 1
-		return tok
-	}
-	p.pos += 1 // to make sure backup() works
-	return token{typ: tEOF}
+func (p *parseState) next() token {
+	tok := p.peekN(0)
+	copy(p.look[:], p.look[1:p.nlook])
+	p.nlook--
+	return tok
 }
 
 // starting point
-//   exprList = expr1*
-func (p *Parser) parseExprList(ctx context) (query.Query, error) {
-	// <empty>
-	if p.peek().typ == tEOF {
-		return bleve.NewMatchNoneQuery(), nil
+//   query = exprList EOF
+func (p *parseState) parseQuery() (*List, error) {
+	l, err := p.parseExprList()
+	if err != nil {
+		return nil, err
+	}
+	// only a stray ) can stop the list early
+	if tok := p.peek(); tok.typ != tEOF {
+		return nil, ParseError{tok.pos, "unexpected )"}
 	}
+	return l, nil
+}
 
-	must := []query.Query{}
-	mustNot := []query.Query{}
-	should := []query.Query{}
+//   exprList = expr1*
+func (p *parseState) parseExprList() (*List, error) {
+	l := &List{}
+	l.From = p.peek().pos
+	l.To = l.From
+	mark := len(p.scratch)
 
 	for {
 		tok := p.peek()
@@ -130,301 +270,207 @@ func (p *Parser) parseExprList(ctx context) (query.Query, error) {
 			break
 		}
 
-		prefix, q, err := p.parseExpr1(ctx)
+		n, err := p.parseExpr1()
 		if err != nil {
 			return nil, err
 		}
-
-		switch prefix {
-		case tPLUS:
-			must = append(must, q)
-		case tMINUS:
-			mustNot = append(mustNot, q)
-		default:
-			if p.DefaultOp == AND {
-				must = append(must, q)
-			} else { // OR
-				should = append(should, q)
-			}
-		}
-	}
-
-	// some obvious shortcuts
-	total := len(must) + len(mustNot) + len(should)
-	if total == 0 {
-		return bleve.NewMatchNoneQuery(), nil
-	}
-	if total == 1 && len(must) == 1 {
-		return must[0], nil
-	}
-	if total == 1 && len(should) == 1 {
-		return should[0], nil
+		p.scratch = append(p.scratch, n)
+		l.To = n.End()
 	}
-
-	// no shortcuts - go with the full-fat version
-	q := bleve.NewBooleanQuery()
-	if len(must) > 0 {
-		q.AddMust(must...)
-	}
-	if len(should) > 0 {
-		q.AddShould(should...)
-	}
-	if len(mustNot) > 0 {
-		q.AddMustNot(mustNot...)
-	}
-	return q, nil
+	l.Clauses = p.collect(mark)
+	return l, nil
 }
 
 // parseExpr1 handles OR expressions
 //
 //   expr1 = expr2 {"OR" expr2}
-func (p *Parser) parseExpr1(ctx context) (tokType, query.Query, error) {
-
-	queries := []query.Query{}
-	prefixes := []tokType{}
-
-	for {
-		prefix, q, err := p.parseExpr2(ctx)
-		if err != nil {
-			return tEOF, nil, err
-		}
+func (p *parseState) parseExpr1() (Node, error) {
 
-		prefixes = append(prefixes, prefix)
-		queries = append(queries, q)
-
-		tok := p.next()
-		if tok.typ != tOR {
-			p.backup()
-			break
-		}
+	n, err := p.parseExpr2()
+	if err != nil {
+		return nil, err
 	}
-
-	// let single, non-OR expressions bubble upward, prefix intact
-	if len(queries) == 1 {
-		return prefixes[0], queries[0], nil
+	// let single, non-OR expressions bubble upward
+	// (without allocating - this is the common case)
+	if p.peek().typ != tOR {
+		return n, nil
 	}
 
-	// KLUDGINESS - prefixes on terms in OR expressions
-	// we'll ignore "+" and treat "-" as NOT
-	// eg:
-	// `+alice OR -bob OR chuck`  => `alice OR (NOT bob) OR chuck`
-	for i, _ := range queries {
-		if prefixes[i] == tMINUS {
-			q := bleve.NewBooleanQuery()
-			q.AddMustNot(queries[i])
-			queries[i] = q
+	mark := len(p.scratch)
+	p.scratch = append(p.scratch, n)
+	for p.peek().typ == tOR {
+		p.next()
+		n, err := p.parseExpr2()
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	return tEOF, bleve.NewDisjunctionQuery(queries...), nil
+		p.scratch = append(p.scratch, n)
+	}
+	operands := p.collect(mark)
+	return &BoolExpr{
+		Span:     Span{operands[0].Pos(), operands[len(operands)-1].End()},
+		Op:       OR,
+		Operands: operands,
+	}, nil
 }
 
 // parseExpr2 handles AND expressions
 //
 //   expr2 = expr3 {"AND" expr3}
-func (p *Parser) parseExpr2(ctx context) (tokType, query.Query, error) {
-
-	queries := []query.Query{}
-	prefixes := []tokType{}
-
-	for {
-		prefix, q, err := p.parseExpr3(ctx)
-		if err != nil {
-			return tEOF, nil, err
-		}
-
-		prefixes = append(prefixes, prefix)
-		queries = append(queries, q)
+func (p *parseState) parseExpr2() (Node, error) {
 
-		tok := p.next()
-		if tok.typ != tAND {
-			p.backup()
-			break
-		}
+	n, err := p.parseExpr3()
+	if err != nil {
+		return nil, err
 	}
-
-	// let single, non-AND expressions bubble upward, prefix intact
-	if len(queries) == 1 {
-		return prefixes[0], queries[0], nil
+	// let single, non-AND expressions bubble upward
+	// (without allocating - this is the common case)
+	if p.peek().typ != tAND {
+		return n, nil
 	}
 
-	// KLUDGINESS - prefixes on terms in AND expressions
-	// we'll ignore "+" and treat "-" as NOT
-	// eg:
-	// `+alice AND -bob AND chuck`  => `alice AND (NOT bob) AND chuck`
-	for i, _ := range queries {
-		if prefixes[i] == tMINUS {
-			q := bleve.NewBooleanQuery()
-			q.AddMustNot(queries[i])
-			queries[i] = q
+	mark := len(p.scratch)
+	p.scratch = append(p.scratch, n)
+	for p.peek().typ == tAND {
+		p.next()
+		n, err := p.parseExpr3()
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	return tEOF, bleve.NewConjunctionQuery(queries...), nil
+		p.scratch = append(p.scratch, n)
+	}
+	operands := p.collect(mark)
+	return &BoolExpr{
+		Span:     Span{operands[0].Pos(), operands[len(operands)-1].End()},
+		Op:       AND,
+		Operands: operands,
+	}, nil
 }
 
 // parseExpr3 handles NOT expressions
 //
 //   expr3 = {"NOT"} expr4
-func (p *Parser) parseExpr3(ctx context) (tokType, query.Query, error) {
+func (p *parseState) parseExpr3() (Node, error) {
 
-	tok := p.next()
-	if tok.typ != tNOT {
-		p.backup()
-		// just let the lower, non-NOT expression bubble up with its prefix
-		return p.parseExpr4(ctx)
+	if p.peek().typ != tNOT {
+		// just let the lower, non-NOT expression bubble up
+		return p.parseExpr4()
 	}
+	tok := p.next()
 
-	prefix, q, err := p.parseExpr4(ctx)
+	n, err := p.parseExpr4()
 	if err != nil {
-		return tEOF, nil, err
-	}
-
-	// KLUDGINESS - prefixes on terms in NOT expressions:
-	// `NOT -bob`  => `bob`
-	// `NOT +bob`  => `NOT bob`
-	if prefix != tMINUS {
-		q2 := bleve.NewBooleanQuery()
-		q2.AddMustNot(q)
-		q = q2
+		return nil, err
 	}
-	return tEOF, q, nil
+	return &NotExpr{Span: Span{tok.pos, n.End()}, X: n}, nil
 }
 
 // Here's where all the prefix-bubbling-up begins...
 //   expr4 = {("+"|"-")} expr5
-func (p *Parser) parseExpr4(ctx context) (tokType, query.Query, error) {
-	var prefix tokType
-	tok := p.next()
-	switch tok.typ {
+func (p *parseState) parseExpr4() (Node, error) {
+	switch p.peek().typ {
 	case tMINUS, tPLUS:
-		prefix = tok.typ
 	default:
-		p.backup()
-		prefix = tEOF
+		return p.parseExpr5()
 	}
+	tok := p.next()
 
-	q, err := p.parseExpr5(ctx)
-	return prefix, q, err
-
+	n, err := p.parseExpr5()
+	if err != nil {
+		return nil, err
+	}
+	return &PrefixExpr{Span: Span{tok.pos, n.End()}, Op: rune(tok.val[0]), X: n}, nil
 }
 
 //   expr5 = {field} part {boost}
-func (p *Parser) parseExpr5(ctx context) (query.Query, error) {
+func (p *parseState) parseExpr5() (Node, error) {
 
 	fldpos := p.peek().pos
 	fld, err := p.parseField()
 	if err != nil {
 		return nil, err
 	}
-	if fld != "" {
-		if ctx.field != "" {
-			return nil, ParseError{fldpos, fmt.Sprintf("'%s:' clashes with '%s:'", fld, ctx.field)}
-		}
-		ctx.field = fld
-		ctx.fieldPos = fldpos
-	}
 
-	q, err := p.parsePart(ctx)
+	n, err := p.parsePart()
 	if err != nil {
 		return nil, err
 	}
+	if fld != "" {
+		n = &FieldExpr{Span: Span{fldpos, n.End()}, Field: fld, X: n}
+	}
 
 	// parse (optional) suffix
-	boostpos := p.peek().pos
+	boostTok := p.peek()
 	boost, err := p.parseBoostSuffix()
 	if err != nil {
 		return nil, err
 	}
 	if boost > 0 {
-		if boostable, ok := q.(query.BoostableQuery); ok {
-			boostable.SetBoost(boost)
-		} else {
-			return nil, ParseError{boostpos, "can't specify a boost value here"}
-		}
+		n = &BoostExpr{Span: Span{n.Pos(), boostTok.end}, X: n, Boost: boost, BoostPos: boostTok.pos}
 	}
 
-	return q, nil
+	return n, nil
 }
 
-//   part = lit {"~" number} | range | "(" exprList ")"
-func (p *Parser) parsePart(ctx context) (query.Query, error) {
+//   part = lit {"~" number} | range | "(" exprList ")" | macro | lookup
+func (p *parseState) parsePart() (Node, error) {
+
+	switch p.peek().typ {
+	//   | range
+	case tLSQUARE, tLBRACE:
+		return p.parseRange()
+	//   | relational
+	case tGREATER, tLESS:
+		return p.parseRelational()
+	}
 
 	tok := p.next()
 
 	//   lit
 	if tok.typ == tLITERAL {
-		var q query.Query
-		if strings.ContainsAny(tok.val, "*?") {
-			q = bleve.NewWildcardQuery(tok.val)
-		} else {
-			if p.peek().typ == tFUZZY {
-				fuzziness, err := p.parseFuzzySuffix()
-				if err != nil {
-					return nil, err
-				}
-				fuzz := bleve.NewFuzzyQuery(tok.val)
-				fuzz.SetFuzziness(fuzziness)
-				q = fuzz
-			} else {
-				q = bleve.NewMatchPhraseQuery(tok.val)
+		if p.Lists != nil && tok.val == "@list" {
+			return p.parseLookup(tok)
+		}
+		if p.Macros != nil {
+			if name, ok := macroName(tok.val); ok {
+				return p.parseMacro(tok, name)
 			}
 		}
-		if ctx.field != "" {
-			if fieldable, ok := q.(query.FieldableQuery); ok {
-				fieldable.SetField(ctx.field)
-			} else {
-				return nil, ParseError{ctx.fieldPos, "unexpected field"}
+		t := &Term{Span: Span{tok.pos, tok.end}, Text: tok.val}
+		if !strings.ContainsAny(tok.val, "*?") && p.peek().typ == tFUZZY {
+			fuzzTok := p.peek()
+			fuzziness, err := p.parseFuzzySuffix()
+			if err != nil {
+				return nil, err
 			}
+			t.Fuzzy = true
+			t.Fuzziness = fuzziness
+			t.To = fuzzTok.end
 		}
-		return q, nil
+		return t, nil
 	}
 	if tok.typ == tQUOTED {
 		// strip quotes (ugh)
-		txt := string(tok.val[1 : len(tok.val)-1])
+		txt := unquote(tok.val)
 		/*
 			if strings.ContainsAny(txt, "*?") {
 				return nil, ParseError{tok.pos, "wildcards not supported in phrases"}
 			}
 		*/
-		q := bleve.NewMatchPhraseQuery(txt)
-		if ctx.field != "" {
-			q.SetField(ctx.field)
-		}
-		return q, nil
+		return &Phrase{Span: Span{tok.pos, tok.end}, Text: txt}, nil
 	}
 
 	//   | "(" exprList ")"
 	if tok.typ == tLPAREN {
-		q, err := p.parseExprList(ctx)
+		l, err := p.parseExprList()
 		if err != nil {
 			return nil, err
 		}
-		tok = p.next()
-		if tok.typ != tRPAREN {
-			return nil, ParseError{tok.pos, "missing )"}
-		}
-		return q, nil
-	}
-
-	//   | range
-	if tok.typ == tLSQUARE || tok.typ == tLBRACE {
-		p.backup()
-		q, err := p.parseRange(ctx)
-		if err != nil {
-			return nil, err
+		closeTok := p.next()
+		if closeTok.typ != tRPAREN {
+			return nil, ParseError{closeTok.pos, "missing )"}
 		}
-		return q, nil
-	}
-
-	//   | relational
-	if tok.typ == tGREATER || tok.typ == tLESS {
-		p.backup()
-		q, err := p.parseRelational(ctx)
-		if err != nil {
-			return nil, err
-		}
-		return q, nil
+		return &Group{Span: Span{tok.pos, closeTok.end}, X: l}, nil
 	}
 
 	if tok.typ == tERROR {
@@ -435,12 +481,11 @@ func (p *Parser) parsePart(ctx context) (query.Query, error) {
 }
 
 // returns >0 if there is a value given
-func (p *Parser) parseBoostSuffix() (float64, error) {
-	tok := p.next()
-	if tok.typ != tBOOST {
-		p.backup()
+func (p *parseState) parseBoostSuffix() (float64, error) {
+	if p.peek().typ != tBOOST {
 		return 0, nil
 	}
+	tok := p.next()
 
 	v := tok.val[1:]
 	if v == "" {
@@ -455,7 +500,7 @@ func (p *Parser) parseBoostSuffix() (float64, error) {
 }
 
 //
-func (p *Parser) parseFuzzySuffix() (int, error) {
+func (p *parseState) parseFuzzySuffix() (int, error) {
 	tok := p.next()
 	if tok.typ != tFUZZY {
 		return 0, ParseError{tok.pos, "expected ~"}
@@ -476,49 +521,37 @@ func (p *Parser) parseFuzzySuffix() (int, error) {
 // parse (optional) field specifier
 // [ lit ":" ]
 // returns field name or "" if not a field
-func (p *Parser) parseField() (string, error) {
-	tok := p.next()
-	if tok.typ != tLITERAL {
+func (p *parseState) parseField() (string, error) {
+	if p.peek().typ != tLITERAL || p.peekN(1).typ != tCOLON {
 		// not a field
-		p.backup()
 		return "", nil
 	}
-	field := tok.val
-
-	tok = p.next()
-	if tok.typ != tCOLON {
-		// oop, not a field after all!
-		p.backup()
-		p.backup()
-		return "", nil
-	}
-
-	return field, nil // it's OK
+	field := p.next().val
+	p.next() // colon
+	return field, nil
 }
 
 //   range = ("["|"}") {lit} "TO" {lit} ("]"|"}")
-func (p *Parser) parseRange(ctx context) (query.Query, error) {
+func (p *parseState) parseRange() (Node, error) {
 
-	var minVal, maxVal string
-	var minInclusive, maxInclusive bool
+	r := &RangeExpr{}
 	openTok := p.next()
 	switch openTok.typ {
 	case tLSQUARE:
-		minInclusive = true
+		r.MinInclusive = true
 	case tLBRACE:
-		minInclusive = false
+		r.MinInclusive = false
 	default:
 		return nil, ParseError{openTok.pos, "expected range"}
 	}
 
-	tok := p.next()
+	tok := p.peek()
 	switch tok.typ {
 	case tLITERAL:
-		minVal = tok.val
+		r.Min = p.next().val
 	case tQUOTED:
-		minVal = tok.val[1 : len(tok.val)-1]
+		r.Min, r.MinQuoted = unquote(p.next().val), true
 	case tTO:
-		p.backup()
 		// empty start
 	default:
 		return nil, ParseError{tok.pos, fmt.Sprintf("unexpected %s", tok.val)}
@@ -529,16 +562,14 @@ func (p *Parser) parseRange(ctx context) (query.Query, error) {
 		return nil, ParseError{tok.pos, "expected TO"}
 	}
 
-	tok = p.next()
+	tok = p.peek()
 	switch tok.typ {
 	case tLITERAL:
-		maxVal = tok.val
+		r.Max = p.next().val
 	case tQUOTED:
-		maxVal = tok.val[1 : len(tok.val)-1]
-	case tRSQUARE:
-		p.backup() // empty end value
-	case tRBRACE:
-		p.backup() // empty end value
+		r.Max, r.MaxQuoted = unquote(p.next().val), true
+	case tRSQUARE, tRBRACE:
+		// empty end value
 	default:
 		return nil, ParseError{tok.pos, fmt.Sprintf("unexpected %s", tok.val)}
 	}
@@ -546,76 +577,53 @@ func (p *Parser) parseRange(ctx context) (query.Query, error) {
 	closeTok := p.next()
 	switch closeTok.typ {
 	case tRSQUARE:
-		maxInclusive = true
+		r.MaxInclusive = true
 	case tRBRACE:
-		maxInclusive = false
+		r.MaxInclusive = false
 	default:
 		return nil, ParseError{closeTok.pos, "expected ] or }"}
 	}
 
-	rp := newRangeParams(minVal, maxVal, minInclusive, maxInclusive, p.Loc)
-	q, err := rp.generate()
-	if err != nil {
-		return nil, ParseError{openTok.pos, err.Error()}
-	}
-	if ctx.field != "" {
-		if fieldable, ok := q.(query.FieldableQuery); ok {
-			fieldable.SetField(ctx.field)
-		} else {
-			return nil, ParseError{ctx.fieldPos, "unexpected field"}
-		}
-	}
-	return q, nil
+	r.Span = Span{openTok.pos, closeTok.end}
+	return r, nil
 }
 
 // parseRelational handles greaterthan/lessthan etc...
 // Implemented as a range.
 //   relational = ("<"|">"|"<="|">=") lit
-func (p *Parser) parseRelational(ctx context) (query.Query, error) {
-
-	var minVal, maxVal string
-	var minInclusive, maxInclusive bool
+func (p *parseState) parseRelational() (Node, error) {
 
 	rel := p.next()
 	if rel.typ != tGREATER && rel.typ != tLESS {
 		return nil, ParseError{rel.pos, "expected > or <"}
 	}
 
-	eq := p.next()
-	if eq.typ != tEQUAL {
-		p.backup()
+	inclusive := p.peek().typ == tEQUAL
+	if inclusive {
+		p.next()
 	}
 
 	var val string
+	var quoted bool
 	tok := p.next()
 	switch tok.typ {
 	case tLITERAL:
 		val = tok.val
 	case tQUOTED:
-		val = tok.val[1 : len(tok.val)-1]
+		val, quoted = unquote(tok.val), true
 	default:
 		return nil, ParseError{tok.pos, fmt.Sprintf("unexpected %s", tok.val)}
 	}
 
+	r := &RangeExpr{Span: Span{rel.pos, tok.end}}
 	if rel.typ == tGREATER {
-		minVal = val
-		minInclusive = (eq.typ == tEQUAL)
+		r.Min = val
+		r.MinInclusive = inclusive
+		r.MinQuoted = quoted
 	} else { // if rel.typ == tLESS
-		maxVal = val
-		maxInclusive = (eq.typ == tEQUAL)
-	}
-
-	rp := newRangeParams(minVal, maxVal, minInclusive, maxInclusive, p.Loc)
-	q, err := rp.generate()
-	if err != nil {
-		return nil, ParseError{rel.pos, err.Error()}
-	}
-	if ctx.field != "" {
-		if fieldable, ok := q.(query.FieldableQuery); ok {
-			fieldable.SetField(ctx.field)
-		} else {
-			return nil, ParseError{ctx.fieldPos, "unexpected field"}
-		}
+		r.Max = val
+		r.MaxInclusive = inclusive
+		r.MaxQuoted = quoted
 	}
-	return q, nil
+	return r, nil
 }
@@ -0,0 +1,88 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/mapping"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a single Parser should be usable from multiple goroutines
+func TestParserConcurrent(t *testing.T) {
+	p := &Parser{
+		DefaultOp: AND,
+		Loc:       time.FixedZone("test", 3600),
+		Macros:    MacroMap{"citrus": `lemon OR lime`},
+		Lists:     TermListMap{"ids": {"1", "2"}},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []string{}
+	expected := []interface{}{}
+	for i := 0; i < 20; i++ {
+		in := fmt.Sprintf(`tags:@citrus +n:[%d TO %d] -id:@list(ids) "phrase %d"^2`, i, i+10, i)
+		q, err := p.Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, in)
+		expected = append(expected, q)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 100)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				i := (g + j) % len(inputs)
+				q, err := p.Parse(inputs[i])
+				if err != nil || !reflect.DeepEqual(q, expected[i]) {
+					errs <- fmt.Sprintf("`%s`: bad result (%v)", inputs[i], err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+}
+
+func TestParserValidate(t *testing.T) {
+	badMapping := mapping.NewIndexMapping()
+	badMapping.DefaultAnalyzer = "no-such-analyzer"
+
+	good := []Parser{
+		{},
+		{DefaultOp: AND, Loc: time.UTC},
+		{Macros: MacroMap{}, MaxMacroDepth: 3},
+		{Macros: MacroMap{"citrus": "lemon"}, Lists: TermListMap{}},
+		{Mapping: mapping.NewIndexMapping()},
+	}
+	for i, p := range good {
+		if err := p.Validate(); err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+	}
+
+	bad := []Parser{
+		{DefaultOp: 2},
+		{MaxMacroDepth: -1, Macros: MacroMap{}},
+		{MaxMacroDepth: 3},
+		{Macros: MacroMap{"list": "x"}, Lists: TermListMap{}},
+		{Mapping: badMapping},
+	}
+	for i, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
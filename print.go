@@ -0,0 +1,255 @@
+package qs
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Canonical printing of syntax trees.
+
+// precedence levels, loosest first, for deciding when to add parentheses
+const (
+	precList   = iota // exprList
+	precOr            // expr1
+	precAnd           // expr2
+	precNot           // expr3
+	precPrefix        // expr4
+	precField         // expr5
+	precPart          // part
+)
+
+// Format renders a syntax tree back into a query string in canonical form:
+// a single space between clauses, uppercase operators, relational operators
+// for half-open ranges and no redundant parentheses. Parentheses are added
+// where needed to preserve the structure of the tree, so the output always
+// parses back into an equivalent tree.
+//
+// The exception is a wildcard or fuzzy Term which would need quoting (eg
+// containing a space), as quoting would make it a phrase and there's no
+// other way to write it. The parser, Builder, templates and UnmarshalTree
+// never produce these, so only trees built by hand or with Rewrite need to
+// avoid them.
+func Format(n Node) string {
+	var sb strings.Builder
+	printNode(&sb, n, precList)
+	return sb.String()
+}
+
+// FormatString parses a query string and returns it in canonical form.
+// See Format.
+func FormatString(q string) (string, error) {
+	tree, err := ParseTree(q)
+	if err != nil {
+		return "", err
+	}
+	return Format(tree), nil
+}
+
+// writable reports whether Format can write a term. Terms which aren't
+// bare literals are quoted, which is fine for plain terms (a phrase matches
+// the same), but there's no way to quote a wildcard or fuzzy term.
+func writable(t *Term) bool {
+	return isBareLiteral(t.Text) || !(t.Fuzzy || containsWildcard(t.Text))
+}
+
+func precedence(n Node) int {
+	switch n := n.(type) {
+	case *List:
+		if len(n.Clauses) == 1 {
+			return precedence(n.Clauses[0])
+		}
+		return precList
+	case *BoolExpr:
+		if n.Op == AND {
+			return precAnd
+		}
+		return precOr
+	case *NotExpr:
+		return precNot
+	case *PrefixExpr:
+		return precPrefix
+	case *FieldExpr, *BoostExpr:
+		return precField
+	}
+	return precPart
+}
+
+// printNode writes out n, wrapping it in parentheses if it binds more
+// loosely than the context requires.
+func printNode(sb *strings.Builder, n Node, minPrec int) {
+	if precedence(n) < minPrec {
+		sb.WriteByte('(')
+		printNode(sb, n, precList)
+		sb.WriteByte(')')
+		return
+	}
+
+	switch n := n.(type) {
+	case *List:
+		for i, clause := range n.Clauses {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			printNode(sb, clause, precOr)
+		}
+	case *BoolExpr:
+		op, prec := " OR ", precAnd
+		if n.Op == AND {
+			op, prec = " AND ", precNot
+		}
+		for i, operand := range n.Operands {
+			if i > 0 {
+				sb.WriteString(op)
+			}
+			printNode(sb, operand, prec)
+		}
+	case *NotExpr:
+		sb.WriteString("NOT ")
+		printNode(sb, n.X, precPrefix)
+	case *PrefixExpr:
+		sb.WriteRune(n.Op)
+		printNode(sb, n.X, precField)
+	case *FieldExpr:
+		sb.WriteString(n.Field)
+		sb.WriteByte(':')
+		printNode(sb, n.X, precPart)
+	case *BoostExpr:
+		// a boost can't be applied to another boost
+		if _, ok := n.X.(*BoostExpr); ok {
+			printNode(sb, n.X, precPart)
+		} else {
+			printNode(sb, n.X, precField)
+		}
+		sb.WriteByte('^')
+		sb.WriteString(strconv.FormatFloat(n.Boost, 'f', -1, 64))
+	case *Group:
+		sb.WriteByte('(')
+		printNode(sb, n.X, precList)
+		sb.WriteByte(')')
+	case *Term:
+		if isBareLiteral(n.Text) {
+			sb.WriteString(n.Text)
+		} else {
+			sb.WriteString(quote(n.Text))
+		}
+		if n.Fuzzy {
+			sb.WriteByte('~')
+			sb.WriteString(strconv.Itoa(n.Fuzziness))
+		}
+	case *Phrase:
+		sb.WriteString(quote(n.Text))
+	case *TermsLookup:
+		sb.WriteString("@list(")
+		sb.WriteString(rangeValue(n.Name, false))
+		sb.WriteByte(')')
+	case *MacroExpr:
+		sb.WriteByte('@')
+		sb.WriteString(n.Name)
+		if n.Args != nil {
+			sb.WriteByte('(')
+			for i, arg := range n.Args {
+				if i > 0 {
+					sb.WriteByte(' ')
+				}
+				sb.WriteString(rangeValue(arg, false))
+			}
+			sb.WriteByte(')')
+		}
+	case *RangeExpr:
+		printRange(sb, n)
+	}
+}
+
+func printRange(sb *strings.Builder, r *RangeExpr) {
+	// half-open ranges are tidier as relational operators
+	switch {
+	case r.Min != "" && r.Max == "":
+		sb.WriteByte('>')
+		if r.MinInclusive {
+			sb.WriteByte('=')
+		}
+		sb.WriteString(rangeValue(r.Min, r.MinQuoted))
+		return
+	case r.Min == "" && r.Max != "":
+		sb.WriteByte('<')
+		if r.MaxInclusive {
+			sb.WriteByte('=')
+		}
+		sb.WriteString(rangeValue(r.Max, r.MaxQuoted))
+		return
+	}
+
+	if r.MinInclusive {
+		sb.WriteByte('[')
+	} else {
+		sb.WriteByte('{')
+	}
+	if r.Min != "" {
+		sb.WriteString(rangeValue(r.Min, r.MinQuoted))
+		sb.WriteByte(' ')
+	}
+	sb.WriteString("TO")
+	if r.Max != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(rangeValue(r.Max, r.MaxQuoted))
+	}
+	if r.MaxInclusive {
+		sb.WriteByte(']')
+	} else {
+		sb.WriteByte('}')
+	}
+}
+
+// rangeValue writes out a range endpoint. Quotes are kept on a quoted
+// endpoint which would otherwise read as a template parameter.
+func rangeValue(v string, quoted bool) string {
+	if _, isParam := paramName(v); isBareLiteral(v) && !(quoted && isParam) {
+		return v
+	}
+	return quote(v)
+}
+
+// isBareLiteral returns true if s would be lexed as a single tLITERAL
+// token with the same text.
+func isBareLiteral(s string) bool {
+	if s == "" {
+		return false
+	}
+	switch s {
+	case "OR", "AND", "NOT", "TO":
+		return false
+	}
+	for i, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune(`:(){}[]^~`, r) {
+			return false
+		}
+		if i == 0 {
+			if _, single := singles[r]; single || r == '"' || r == '\'' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// quote wraps s in quotes, using single quotes if s contains double quotes.
+func quote(s string) string {
+	q := byte('"')
+	if strings.ContainsRune(s, '"') && !strings.ContainsRune(s, '\'') {
+		q = '\''
+	}
+	if !strings.ContainsRune(s, rune(q)) && !strings.ContainsRune(s, '\\') {
+		return string(q) + s + string(q)
+	}
+	buf := make([]byte, 0, len(s)+4)
+	buf = append(buf, q)
+	for i := 0; i < len(s); i++ {
+		if s[i] == q || s[i] == '\\' {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, s[i])
+	}
+	buf = append(buf, q)
+	return string(buf)
+}
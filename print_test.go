@@ -0,0 +1,140 @@
+package qs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{``, ``},
+		{`  grapefruit   lemon `, `grapefruit lemon`},
+		{`tags:(lemon   mango)`, `tags:(lemon mango)`},
+		{`((a))`, `((a))`},
+		{`a OR b AND NOT c`, `a OR b AND NOT c`},
+		{`-"navel orange"^2 +colour~`, `-"navel orange"^2 +colour~1`},
+		{`'say "hi"'`, `'say "hi"'`},
+		{`grapefruit^`, `grapefruit^1`},
+		{`num:[1 TO 5}`, `num:[1 TO 5}`},
+		{`date:[2000-01-01 TO ]`, `date:>=2000-01-01`},
+		{`temp:{TO 100}`, `temp:<100`},
+		{`n:[ "a b" TO 'c' ]`, `n:["a b" TO c]`},
+		{`x:>= 10`, `x:>=10`},
+		{`"say \"hi\""`, `'say "hi"'`},
+		{`'it\'s "hi"'`, `"it's \"hi\""`},
+		{`"C:\Windows\\"`, `"C:\\Windows\\"`},
+	}
+
+	for _, dat := range data {
+		got, err := FormatString(dat.input)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		if got != dat.expected {
+			t.Errorf("Expected `%s`, got `%s`: for `%s`", dat.expected, got, dat.input)
+		}
+	}
+}
+
+// Format should add parentheses where needed to preserve the tree
+func TestFormatParens(t *testing.T) {
+	a := &Term{Text: "a"}
+	b := &Term{Text: "b"}
+	data := []struct {
+		tree     Node
+		expected string
+	}{
+		{&BoolExpr{Op: AND, Operands: []Node{&BoolExpr{Op: OR, Operands: []Node{a, b}}, a}}, `(a OR b) AND a`},
+		{&FieldExpr{Field: "f", X: &List{Clauses: []Node{a, b}}}, `f:(a b)`},
+		{&BoostExpr{X: &BoostExpr{X: a, Boost: 2}, Boost: 3}, `(a^2)^3`},
+		{&NotExpr{X: &NotExpr{X: a}}, `NOT (NOT a)`},
+		{&PrefixExpr{Op: '-', X: &PrefixExpr{Op: '+', X: a}}, `-(+a)`},
+		{&Term{Text: "OR"}, `"OR"`},
+		{&Term{Text: "-x"}, `"-x"`},
+		{&Term{Text: "a:b"}, `"a:b"`},
+	}
+
+	for _, dat := range data {
+		got := Format(dat.tree)
+		if got != dat.expected {
+			t.Errorf("Expected `%s`, got `%s`", dat.expected, got)
+		}
+	}
+}
+
+// formatted queries should compile to the same thing as the originals
+// terms which can't be written bare are quoted, which is only safe for plain
+// terms
+func TestFormatTermRoundTrip(t *testing.T) {
+	terms := []*Term{
+		{Text: "a b"},
+		{Text: "OR"},
+		{Text: "(x)"},
+		{Text: "f??t"},
+		{Text: "colour", Fuzzy: true, Fuzziness: 2},
+	}
+	var p Parser
+	for _, term := range terms {
+		tree := &List{Clauses: []Node{term}}
+		expected, err := p.Compile(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Parse(Format(tree))
+		if err != nil {
+			t.Fatalf("`%s`: %s", Format(tree), err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("`%s` compiles differently to the term %q", Format(tree), term.Text)
+		}
+	}
+
+	for _, term := range []*Term{
+		{Text: "a b", Fuzzy: true, Fuzziness: 1},
+		{Text: "a b*"},
+		{Text: "OR", Fuzzy: true},
+	} {
+		if writable(term) {
+			t.Errorf("%q shouldn't be writable", term.Text)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	inputs := []string{
+		`grapefruit lemon orange lime`,
+		`+tags:citrus -tags:paint "navel orange"^4 colour~2`,
+		`(shaddock OR pomelo OR pamplemousse) AND (family:rutaceae AND NOT genus:fortunella) AND colour:(greenish OR yellowish)`,
+		`NOT -bob NOT +alice`,
+		`+alice OR -bob OR chuck`,
+		`pubdate:[2010-01-01 TO 2011-01-01} shoesize:{0 TO 16] score:<=100`,
+		`(grapefruit OR orange)^2 f??t ant*`,
+		`a:()`,
+		`(a^2 b~1)^3`,
+	}
+
+	for _, in := range inputs {
+		expected, err := Parse(in)
+		if err != nil {
+			t.Fatalf("`%s`: %s", in, err)
+		}
+		out, err := FormatString(in)
+		if err != nil {
+			t.Fatalf("`%s`: %s", in, err)
+		}
+		got, err := Parse(out)
+		if err != nil {
+			t.Fatalf("`%s` (formatted from `%s`): %s", out, in, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("`%s` and `%s` differ: %#v vs %#v", in, out, expected, got)
+		}
+		again, _ := FormatString(out)
+		if again != out {
+			t.Errorf("Format not idempotent: `%s` => `%s`", out, again)
+		}
+	}
+}
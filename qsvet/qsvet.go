@@ -0,0 +1,186 @@
+// Package qsvet provides an analyzer which checks constant query strings
+// passed to qs.Parse and friends, so that syntax errors are caught at
+// build time rather than at runtime.
+//
+// It can be run under go vet via the qsvet command:
+//
+//   $ go install github.com/bcampbell/qs/cmd/qsvet
+//   $ go vet -vettool=$(which qsvet) ./...
+//
+// or added to any other analysis driver (eg gopls) using Analyzer.
+//
+// If the -fields flag is set to a comma-separated list of field names,
+// queries using any other fields are reported too. Names may use
+// path.Match wildcards, eg "meta.*".
+package qsvet
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bcampbell/qs"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const qsPath = "github.com/bcampbell/qs"
+
+// Analyzer reports malformed constant query strings passed to qs.Parse,
+// qs.ParseTree and the Parser methods of the same names.
+var Analyzer = &analysis.Analyzer{
+	Name:     "qsparse",
+	Doc:      "check constant query strings passed to qs.Parse",
+	Run:      run,
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+}
+
+// fields is the allowlist set by -fields
+var fields string
+
+func init() {
+	Analyzer.Flags.StringVar(&fields, "fields", "", "comma-separated list of allowed field names (default is to allow any field)")
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	allowed := []string{}
+	for _, f := range strings.Split(fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			allowed = append(allowed, f)
+		}
+	}
+
+	ins := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	ins.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+		call := n.(*ast.CallExpr)
+		name, ok := parseFunc(pass.TypesInfo, call)
+		if !ok || len(call.Args) != 1 {
+			return
+		}
+		arg := call.Args[0]
+		tv, ok := pass.TypesInfo.Types[arg]
+		if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+			return // not a constant
+		}
+		q := constant.StringVal(tv.Value)
+
+		var p qs.Parser
+		tree, err := p.ParseTree(q)
+		if err == nil {
+			_, err = p.Compile(tree)
+		}
+		if pe, ok := err.(qs.ParseError); ok {
+			pass.Reportf(queryPos(arg, pe.Pos), "bad query passed to %s: %s", name, pe.Msg)
+			return
+		}
+		if err != nil || len(allowed) == 0 {
+			return
+		}
+		qs.Inspect(tree, func(n qs.Node) bool {
+			f, ok := n.(*qs.FieldExpr)
+			if ok && !fieldAllowed(f.Field, allowed) {
+				pass.Reportf(queryPos(arg, f.Pos()), "unknown field '%s' in query passed to %s", f.Field, name)
+			}
+			return true
+		})
+	})
+	return nil, nil
+}
+
+// parseFunc checks if a call is to one of the qs parsing functions, and
+// returns its name if so.
+func parseFunc(info *types.Info, call *ast.CallExpr) (string, bool) {
+	fn, ok := typeutil.Callee(info, call).(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != qsPath {
+		return "", false
+	}
+	switch fn.Name() {
+	case "Parse", "ParseTree":
+	default:
+		return "", false
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() == nil {
+		return "qs." + fn.Name(), true
+	}
+	recv := sig.Recv().Type()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	if named, ok := recv.(*types.Named); ok && named.Obj().Name() == "Parser" {
+		return "(*qs.Parser)." + fn.Name(), true
+	}
+	return "", false
+}
+
+func fieldAllowed(field string, allowed []string) bool {
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// queryPos maps an offset within a query string back to a position in the
+// source. If the query is a string literal, this is the exact position of
+// the offending character within the literal. Otherwise (eg a named
+// constant, or a concatenation) it's the start of the expression.
+func queryPos(arg ast.Expr, offset int) token.Pos {
+	for {
+		paren, ok := arg.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		arg = paren.X
+	}
+	lit, ok := arg.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return arg.Pos()
+	}
+	return lit.Pos() + token.Pos(literalOffset(lit.Value, offset))
+}
+
+// literalOffset converts an offset into the value of a Go string literal
+// into an offset into its source, allowing for the opening quote and any
+// escape sequences.
+func literalOffset(src string, offset int) int {
+	if src == "" {
+		return 0
+	}
+	quote := src[0]
+	if quote == '`' {
+		// raw strings have no escapes (carriage returns are discarded, but
+		// that's not worth worrying about)
+		return 1 + offset
+	}
+
+	pos := 1 // skip opening quote
+	decoded := 0
+	s := src[1 : len(src)-1]
+	for len(s) > 0 && decoded < offset {
+		value, multibyte, tail, err := strconv.UnquoteChar(s, quote)
+		if err != nil {
+			break
+		}
+		// \x escapes produce a single byte, even if >= 0x80
+		n := 1
+		if multibyte {
+			n = utf8.RuneLen(value)
+		}
+		if decoded+n > offset {
+			break // offset is in the middle of this char
+		}
+		decoded += n
+		pos += len(s) - len(tail)
+		s = tail
+	}
+	return pos
+}
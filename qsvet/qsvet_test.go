@@ -0,0 +1,34 @@
+package qsvet
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	fields = "status,title,tags,date,foo,bar,meta.*"
+	defer func() { fields = "" }()
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "a")
+}
+
+func TestLiteralOffset(t *testing.T) {
+	data := []struct {
+		src    string
+		offset int
+		want   int
+	}{
+		{"`a:b`", 2, 3},
+		{`"a:b"`, 2, 3},
+		{`"\tx)"`, 2, 4},
+		{`"\"x\" )"`, 4, 7},
+		{`"é )"`, 3, 4},
+		{`"\u00e9 )"`, 3, 8},
+		{`"\xe9 )"`, 2, 6},
+	}
+	for _, dat := range data {
+		if got := literalOffset(dat.src, dat.offset); got != dat.want {
+			t.Errorf("%s offset %d: expected %d, got %d", dat.src, dat.offset, dat.want, got)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package a
+
+import "github.com/bcampbell/qs"
+
+const goodQuery = "tags:citrus"
+const badQuery = "tags:(citrus"
+
+func f(userInput string) {
+	qs.Parse("status:active AND title:lemon")
+	qs.Parse("status:active AND )")     // want `bad query passed to qs.Parse: unexpected \)`
+	qs.Parse(`date:[2015-01-01 TO x]`) // want `bad query passed to qs.Parse: not numeric`
+	qs.ParseTree("\"unclosed")        // want `bad query passed to qs.ParseTree: unclosed quote`
+	qs.Parse(goodQuery)
+	qs.Parse(badQuery) // want `bad query passed to qs.Parse: missing \)`
+	qs.Parse(userInput)
+
+	p := &qs.Parser{}
+	p.Parse("foo:(bar:baz)") // want `bad query passed to \(\*qs.Parser\).Parse: 'bar:' clashes with 'foo:'`
+	p.Parse("colour:red")    // want `unknown field 'colour' in query passed to \(\*qs.Parser\).Parse`
+	p.Parse("meta.author:bob")
+}
@@ -0,0 +1,9 @@
+// stub of the real package, for the analyzer tests
+package qs
+
+type Parser struct{}
+
+func (p *Parser) Parse(q string) (interface{}, error)     { return nil, nil }
+func (p *Parser) ParseTree(q string) (interface{}, error) { return nil, nil }
+func Parse(q string) (interface{}, error)                 { return nil, nil }
+func ParseTree(q string) (interface{}, error)             { return nil, nil }
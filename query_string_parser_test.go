@@ -767,6 +767,8 @@ func TestQuerySyntaxParserInvalid(t *testing.T) {
 		{`cat^3\0`},
 		{`cat~3\:`},
 		{`cat~3\0`},
+		{"a ) b"},
+		{"(a))"},
 	}
 
 	for _, test := range tests {
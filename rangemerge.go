@@ -0,0 +1,300 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve/search/query"
+	"time"
+)
+
+// Merging of range queries.
+//
+// Ranges on the same field can be combined: under AND they're intersected
+// (so price:>10 AND price:<=50 AND price:[20 TO 100] becomes
+// price:[20 TO 50]), and under OR, overlapping or touching ranges are
+// joined. An intersection which can't match anything (eg price:>50 AND
+// price:<10) makes the whole conjunction match nothing.
+//
+// This works on the compiled queries, so the bounds are exactly those
+// rangeParams produced (eg date ranges are already whole days, from an
+// inclusive start to an exclusive end). Unset inclusive flags take bleve's
+// defaults: inclusive minimum, exclusive maximum.
+//
+// Intersecting assumes a field holds a single value per document. A
+// document with price [60 5] would match price:>50 AND price:<10, but not
+// the merged query.
+
+const (
+	numericInterval = iota
+	dateInterval
+	termInterval
+)
+
+// interval is the common form of numeric, date and term ranges
+type interval struct {
+	kind  int
+	field string
+	lo    *endpoint // nil if unbounded
+	hi    *endpoint
+}
+
+type endpoint struct {
+	v   interface{} // float64, time.Time or string
+	inc bool
+}
+
+// toInterval converts a range query (without a boost) into an interval
+func toInterval(q query.Query) (interval, bool) {
+	if boosted(q) {
+		return interval{}, false
+	}
+	switch q := q.(type) {
+	case *query.NumericRangeQuery:
+		iv := interval{kind: numericInterval, field: q.FieldVal}
+		if q.Min != nil {
+			iv.lo = &endpoint{*q.Min, inclusive(q.InclusiveMin, true)}
+		}
+		if q.Max != nil {
+			iv.hi = &endpoint{*q.Max, inclusive(q.InclusiveMax, false)}
+		}
+		return iv, iv.lo != nil || iv.hi != nil
+	case *query.DateRangeQuery:
+		iv := interval{kind: dateInterval, field: q.FieldVal}
+		if !q.Start.IsZero() {
+			iv.lo = &endpoint{q.Start.Time, inclusive(q.InclusiveStart, true)}
+		}
+		if !q.End.IsZero() {
+			iv.hi = &endpoint{q.End.Time, inclusive(q.InclusiveEnd, false)}
+		}
+		return iv, iv.lo != nil || iv.hi != nil
+	case *query.TermRangeQuery:
+		iv := interval{kind: termInterval, field: q.FieldVal}
+		if q.Min != "" {
+			iv.lo = &endpoint{q.Min, inclusive(q.InclusiveMin, true)}
+		}
+		if q.Max != "" {
+			iv.hi = &endpoint{q.Max, inclusive(q.InclusiveMax, false)}
+		}
+		return iv, iv.lo != nil || iv.hi != nil
+	}
+	return interval{}, false
+}
+
+func inclusive(flag *bool, def bool) bool {
+	if flag == nil {
+		return def
+	}
+	return *flag
+}
+
+// query turns the interval back into a range query
+func (iv interval) query() query.Query {
+	var minInc, maxInc *bool
+	if iv.lo != nil {
+		minInc = &iv.lo.inc
+	}
+	if iv.hi != nil {
+		maxInc = &iv.hi.inc
+	}
+	var q query.Query
+	switch iv.kind {
+	case numericInterval:
+		var min, max *float64
+		if iv.lo != nil {
+			f := iv.lo.v.(float64)
+			min = &f
+		}
+		if iv.hi != nil {
+			f := iv.hi.v.(float64)
+			max = &f
+		}
+		q = query.NewNumericRangeInclusiveQuery(min, max, minInc, maxInc)
+	case dateInterval:
+		var start, end time.Time
+		if iv.lo != nil {
+			start = iv.lo.v.(time.Time)
+		}
+		if iv.hi != nil {
+			end = iv.hi.v.(time.Time)
+		}
+		q = query.NewDateRangeInclusiveQuery(start, end, minInc, maxInc)
+	default:
+		var min, max string
+		if iv.lo != nil {
+			min = iv.lo.v.(string)
+		}
+		if iv.hi != nil {
+			max = iv.hi.v.(string)
+		}
+		q = query.NewTermRangeInclusiveQuery(min, max, minInc, maxInc)
+	}
+	if iv.field != "" {
+		q.(query.FieldableQuery).SetField(iv.field)
+	}
+	return q
+}
+
+// compareValues compares two endpoint values of the same kind
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+	case time.Time:
+		b := b.(time.Time)
+		if a.Before(b) {
+			return -1
+		} else if a.After(b) {
+			return 1
+		}
+	case string:
+		b := b.(string)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+	}
+	return 0
+}
+
+// empty returns true if nothing can fall within the interval
+func (iv interval) empty() bool {
+	if iv.lo == nil || iv.hi == nil {
+		return false
+	}
+	c := compareValues(iv.lo.v, iv.hi.v)
+	return c > 0 || (c == 0 && !(iv.lo.inc && iv.hi.inc))
+}
+
+// intersect returns the values in both a and b
+func intersect(a, b interval) interval {
+	out := a
+	if a.lo == nil || (b.lo != nil && compareValues(b.lo.v, a.lo.v) > 0) {
+		out.lo = b.lo
+	} else if b.lo != nil && compareValues(b.lo.v, a.lo.v) == 0 {
+		out.lo = &endpoint{a.lo.v, a.lo.inc && b.lo.inc}
+	}
+	if a.hi == nil || (b.hi != nil && compareValues(b.hi.v, a.hi.v) < 0) {
+		out.hi = b.hi
+	} else if b.hi != nil && compareValues(b.hi.v, a.hi.v) == 0 {
+		out.hi = &endpoint{a.hi.v, a.hi.inc && b.hi.inc}
+	}
+	return out
+}
+
+// union returns the values in either a or b, if that can be expressed as
+// a single range - they must overlap or touch, and the result can't be
+// unbounded at both ends.
+func union(a, b interval) (interval, bool) {
+	if a.empty() {
+		return b, true
+	}
+	if b.empty() {
+		return a, true
+	}
+	if gap(a.hi, b.lo) || gap(b.hi, a.lo) {
+		return interval{}, false
+	}
+	out := a
+	if a.lo == nil || b.lo == nil {
+		out.lo = nil
+	} else if c := compareValues(b.lo.v, a.lo.v); c < 0 {
+		out.lo = b.lo
+	} else if c == 0 {
+		out.lo = &endpoint{a.lo.v, a.lo.inc || b.lo.inc}
+	}
+	if a.hi == nil || b.hi == nil {
+		out.hi = nil
+	} else if c := compareValues(b.hi.v, a.hi.v); c > 0 {
+		out.hi = b.hi
+	} else if c == 0 {
+		out.hi = &endpoint{a.hi.v, a.hi.inc || b.hi.inc}
+	}
+	return out, out.lo != nil || out.hi != nil
+}
+
+// gap returns true if there are values between upper bound hi and a
+// higher lower bound lo
+func gap(hi, lo *endpoint) bool {
+	if hi == nil || lo == nil {
+		return false
+	}
+	c := compareValues(hi.v, lo.v)
+	return c < 0 || (c == 0 && !hi.inc && !lo.inc)
+}
+
+// mergeRanges combines the range queries in qs which share a field, by
+// intersecting them (and) or joining them (!and). Other queries are left
+// alone. Returns false if an intersection is empty.
+func mergeRanges(qs []query.Query, and bool) ([]query.Query, bool) {
+	type slot struct {
+		q      query.Query // the original, if nothing was merged into it
+		iv     interval
+		merged bool
+	}
+	var slots []*slot
+	var others []query.Query
+	for _, q := range qs {
+		iv, ok := toInterval(q)
+		if !ok {
+			others = append(others, q)
+			continue
+		}
+		var into *slot
+		for _, s := range slots {
+			if s.iv.kind != iv.kind || s.iv.field != iv.field {
+				continue
+			}
+			if and {
+				s.iv = intersect(s.iv, iv)
+				into = s
+				break
+			}
+			if u, ok := union(s.iv, iv); ok {
+				s.iv = u
+				into = s
+				break
+			}
+		}
+		if into == nil {
+			slots = append(slots, &slot{q: q, iv: iv})
+		} else {
+			into.merged = true
+		}
+	}
+
+	// joining ranges can close the gap between earlier ones
+	for changed := !and; changed; {
+		changed = false
+		for i := 0; i < len(slots) && !changed; i++ {
+			for j := i + 1; j < len(slots); j++ {
+				a, b := slots[i], slots[j]
+				if a.iv.kind != b.iv.kind || a.iv.field != b.iv.field {
+					continue
+				}
+				if u, ok := union(a.iv, b.iv); ok {
+					a.iv, a.merged = u, true
+					slots = append(slots[:j], slots[j+1:]...)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	out := others
+	for _, s := range slots {
+		if and && s.iv.empty() {
+			return nil, false
+		}
+		if s.merged {
+			out = append(out, s.iv.query())
+		} else {
+			out = append(out, s.q)
+		}
+	}
+	return out, true
+}
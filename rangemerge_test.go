@@ -0,0 +1,128 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMergeRanges(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`price:>10 AND price:<=50 AND price:[20 TO 100]`, `price:[20 TO 50]`},
+		{`price:>50 AND price:<10`, `@none`},
+		{`price:>=10 AND price:<=10`, `price:[10 TO 10]`},
+		{`price:>10 AND price:<=10`, `@none`},
+		{`price:[10 TO 20} AND price:[20 TO 30]`, `@none`},
+		{`price:>10 AND other:<5`, `price:>10 AND other:<5`},
+		{`+foo +price:>10 +price:>20`, `foo AND price:>20`},
+		{`price:[1 TO 5] OR price:[3 TO 9]`, `price:[1 TO 9]`},
+		{`price:[1 TO 5} OR price:[5 TO 9]`, `price:[1 TO 9]`},
+		{`price:[1 TO 5} OR price:{5 TO 9]`, `price:[1 TO 5} OR price:{5 TO 9]`},
+		{`price:[1 TO 2] OR price:[5 TO 6] OR price:[2 TO 5]`, `price:[1 TO 6]`},
+		{`price:<5 OR price:>=5`, `price:<5 OR price:>=5`},
+		{`foo -price:<5 -price:[3 TO 8]`, `foo -price:<=8`},
+		{`date:>=2016-01-01 AND date:<=2016-01-31 AND date:>2016-01-14`, `date:[2016-01-15 TO 2016-01-31]`},
+		{`date:>2016-01-05 AND date:<2016-01-06`, `@none`},
+		{`date:[2016-01-01 TO 2016-01-05] OR date:[2016-01-06 TO 2016-01-10]`, `date:[2016-01-01 TO 2016-01-10]`},
+		{`n:>1 AND date:>2016-01-01`, `n:>1 AND date:>2016-01-01`},
+		{`(price:>10)^2 AND price:<5`, `(price:>10)^2 AND price:<5`},
+	}
+
+	for _, dat := range data {
+		q, err := Parse(dat.input)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.input, err)
+		}
+		var expected query.Query = query.NewMatchNoneQuery()
+		if dat.expected != "@none" {
+			if expected, err = Parse(dat.expected); err != nil {
+				t.Fatalf("`%s`: %s", dat.expected, err)
+			}
+			expected = Normalize(expected)
+		}
+		if got := Normalize(q); queryKey(got) != queryKey(expected) {
+			t.Errorf("`%s`: got %s, expected %s", dat.input, queryKey(got), queryKey(expected))
+		}
+	}
+}
+
+func TestMergeTermRanges(t *testing.T) {
+	inc := true
+	a := query.NewTermRangeQuery("apple", "melon")
+	b := query.NewTermRangeInclusiveQuery("kiwi", "pear", &inc, &inc)
+	a.SetField("fruit")
+	b.SetField("fruit")
+
+	expected := query.NewTermRangeInclusiveQuery("kiwi", "melon", &inc, new(bool))
+	expected.SetField("fruit")
+	if got := Normalize(query.NewConjunctionQuery([]query.Query{a, b})); !reflect.DeepEqual(got, expected) {
+		t.Errorf("AND: got %s", queryKey(got))
+	}
+
+	expected = query.NewTermRangeInclusiveQuery("apple", "pear", &inc, &inc)
+	expected.SetField("fruit")
+	if got := Normalize(query.NewDisjunctionQuery([]query.Query{a, b})); !reflect.DeepEqual(got, expected) {
+		t.Errorf("OR: got %s", queryKey(got))
+	}
+}
+
+// merged ranges should find the same documents
+func TestMergeRangesSearch(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		doc := map[string]interface{}{
+			"n":    float64(i),
+			"date": start.AddDate(0, 0, i),
+		}
+		if err := idx.Index(fmt.Sprintf("%d", i), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hits := func(q query.Query) []string {
+		res, err := idx.Search(bleve.NewSearchRequestOptions(q, 100, 0, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	inputs := []string{
+		`n:>3 AND n:<=12 AND n:[5 TO 100]`,
+		`n:[1 TO 5} OR n:[5 TO 7] OR n:{7 TO 9]`,
+		`n:[1 TO 5} OR n:{5 TO 7]`,
+		`n:>=10 -n:<12 -n:[11 TO 15]`,
+		`date:>2016-01-03 AND date:<=2016-01-09 AND date:[2016-01-05 TO 2016-02-01]`,
+		`date:[2016-01-01 TO 2016-01-03] OR date:[2016-01-04 TO 2016-01-06]`,
+		`date:>2016-01-05 AND date:<2016-01-07`,
+	}
+	for _, in := range inputs {
+		q, err := Parse(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h1, h2 := hits(q), hits(Normalize(q))
+		if len(h1) == 0 {
+			t.Errorf("`%s`: expected some hits", in)
+		}
+		if !reflect.DeepEqual(h1, h2) {
+			t.Errorf("`%s`: got %v merged, %v originally", in, h2, h1)
+		}
+	}
+}
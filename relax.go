@@ -0,0 +1,422 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Relaxation is a loosened version of a query, as returned by Relax.
+type Relaxation struct {
+	// Query is the relaxed query string (the original, if it needed no
+	// relaxing)
+	Query string
+	// Tree is the syntax tree of the relaxed query
+	Tree Node
+	// Total is the number of documents the relaxed query matches (0 if
+	// relaxing didn't help)
+	Total uint64
+	// Steps describes what was relaxed, in order, eg "dropped `tart`"
+	Steps []string
+}
+
+// Relax runs a query against an index, loosening it until it finds
+// something, using the default Parser. See Parser.Relax.
+func Relax(idx bleve.Index, q string) (*Relaxation, error) {
+	p := Parser{DefaultOp: OR}
+	return p.Relax(idx, q)
+}
+
+// Relax runs a query against an index, and if nothing matches, weakens the
+// query bit by bit until something does. In order, it:
+//
+//   - drops required clauses with a lower boost than the others required
+//     alongside them, lowest first
+//   - turns AND into OR (including lists of + clauses, and lists under
+//     DefaultOp AND)
+//   - lets terms of four or more letters be misspelt (lemon => lemon~1)
+//   - widens ranges, up to three times
+//
+// checking after each step. Anything excluded by NOT or - stays excluded,
+// and macro expansions are left alone.
+//
+// The result holds the relaxed query string, for "showing results for..."
+// messages, along with descriptions of each step. If nothing helps,
+// Total is 0 and the query is as relaxed as it gets.
+//
+// Returned errors are from parsing q or searching the index.
+func (p *Parser) Relax(idx bleve.Index, q string) (*Relaxation, error) {
+	tree, err := p.ParseTree(q)
+	if err != nil {
+		return nil, err
+	}
+	r := relaxer{p: p, idx: idx, tree: tree}
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+	if r.total > 0 {
+		return &Relaxation{Query: q, Tree: tree, Total: r.total}, nil
+	}
+	for _, step := range []func() (bool, error){r.dropWeakest, r.andToOr, r.fuzz, r.widen, r.widen, r.widen} {
+		done, err := step()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+	return &Relaxation{Query: Format(r.tree), Tree: r.tree, Total: r.total, Steps: r.steps}, nil
+}
+
+type relaxer struct {
+	p     *Parser
+	idx   bleve.Index
+	tree  Node
+	total uint64
+	steps []string
+}
+
+// check counts the documents matched by the current tree
+func (r *relaxer) check() error {
+	q, err := r.p.Compile(r.tree)
+	if err != nil {
+		return err
+	}
+	r.total, err = countHits(r.idx, q)
+	return err
+}
+
+// replace swaps a node in the tree for another (or removes it, if to is
+// nil)
+func (r *relaxer) replace(from, to Node) {
+	r.tree = Rewrite(r.tree, func(n Node) Node {
+		if n == from {
+			return to
+		}
+		return n
+	})
+}
+
+// dropWeakest drops low-boost required clauses one at a time
+func (r *relaxer) dropWeakest() (bool, error) {
+	for {
+		var weakest *weighted
+		for _, group := range r.scan().required {
+			max := group[0].weight
+			for _, w := range group {
+				if w.weight > max {
+					max = w.weight
+				}
+			}
+			for i, w := range group {
+				if w.weight < max && (weakest == nil || w.weight < weakest.weight) {
+					weakest = &group[i]
+				}
+			}
+		}
+		if weakest == nil {
+			return false, nil
+		}
+		r.replace(weakest.clause, nil)
+		r.steps = append(r.steps, fmt.Sprintf("dropped `%s`", Format(weakest.clause)))
+		if err := r.check(); err != nil || r.total > 0 {
+			return true, err
+		}
+	}
+}
+
+// andToOr makes every set of required clauses optional
+func (r *relaxer) andToOr() (bool, error) {
+	changed := false
+	for {
+		ands := r.scan().ands
+		if len(ands) == 0 {
+			break
+		}
+		from := ands[0]
+		to := r.orElse(from)
+		r.replace(from, to)
+		r.steps = append(r.steps, fmt.Sprintf("changed `%s` to `%s`", Format(from), Format(to)))
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	err := r.check()
+	return r.total > 0, err
+}
+
+// orElse returns a version of an AND expression or a list with required
+// clauses which needs just one of them. Negated clauses stay required, so
+// anything excluded stays excluded.
+func (r *relaxer) orElse(n Node) Node {
+	switch n := n.(type) {
+	case *BoolExpr:
+		alternatives := &BoolExpr{Op: OR}
+		var excluded []Node
+		for _, operand := range n.Operands {
+			if negated(operand) {
+				excluded = append(excluded, operand)
+			} else {
+				alternatives.Operands = append(alternatives.Operands, operand)
+			}
+		}
+		if len(excluded) == 0 {
+			return alternatives
+		}
+		cpy := *n
+		cpy.Operands = append([]Node{alternatives}, excluded...)
+		return &cpy
+	case *List:
+		cpy := *n
+		cpy.Clauses = nil
+		var alternatives *BoolExpr
+		for _, clause := range n.Clauses {
+			pe, ok := clause.(*PrefixExpr)
+			switch {
+			case negated(clause):
+				cpy.Clauses = append(cpy.Clauses, clause)
+			case r.p.DefaultOp == OR && ok:
+				cpy.Clauses = append(cpy.Clauses, pe.X)
+			case r.p.DefaultOp == OR:
+				cpy.Clauses = append(cpy.Clauses, clause)
+			default:
+				// under AND, the required clauses have to be gathered
+				// into an explicit OR
+				if ok {
+					clause = pe.X
+				}
+				if alternatives == nil {
+					alternatives = &BoolExpr{Op: OR}
+					cpy.Clauses = append(cpy.Clauses, alternatives)
+				}
+				alternatives.Operands = append(alternatives.Operands, clause)
+			}
+		}
+		return &cpy
+	}
+	return n
+}
+
+// negated reports whether a clause excludes documents (NOT x or -x)
+func negated(n Node) bool {
+	switch n := n.(type) {
+	case *NotExpr:
+		return true
+	case *PrefixExpr:
+		return n.Op == '-'
+	}
+	return false
+}
+
+// fuzz allows for misspellings of the terms
+func (r *relaxer) fuzz() (bool, error) {
+	terms := r.scan().terms
+	if len(terms) == 0 {
+		return false, nil
+	}
+	texts := make([]string, len(terms))
+	for i, t := range terms {
+		fuzzed := *t
+		fuzzed.Fuzzy, fuzzed.Fuzziness = true, 1
+		if utf8.RuneCountInString(t.Text) >= 8 {
+			fuzzed.Fuzziness = 2
+		}
+		r.replace(t, &fuzzed)
+		texts[i] = "`" + Format(t) + "`"
+	}
+	r.steps = append(r.steps, "allowed misspellings of "+joinPhrases(texts, "and"))
+	err := r.check()
+	return r.total > 0, err
+}
+
+// widen widens each range (numbers or dates) in the query
+func (r *relaxer) widen() (bool, error) {
+	changed := false
+	for _, fr := range r.scan().ranges {
+		wider, ok := r.wider(fr.r)
+		if !ok {
+			continue
+		}
+		r.replace(fr.r, wider)
+		from, to := Format(fr.r), Format(wider)
+		if fr.field != "" {
+			from, to = fr.field+":"+from, fr.field+":"+to
+		}
+		r.steps = append(r.steps, fmt.Sprintf("widened `%s` to `%s`", from, to))
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	err := r.check()
+	return r.total > 0, err
+}
+
+// wider returns a range which extends rng by its width at each end, or for
+// an open-ended range, moves the bound out by half its value (numbers, at
+// least 1) or by a year (dates).
+func (r *relaxer) wider(rng *RangeExpr) (*RangeExpr, bool) {
+	out := *rng
+	rp := newRangeParams(rng.Min, rng.Max, rng.MinInclusive, rng.MaxInclusive, r.p.Loc)
+	if isNumeric, min, max := rp.numericArgs(); isNumeric {
+		format := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+		var step float64
+		if min != nil && max != nil {
+			step = *max - *min
+		}
+		if min != nil {
+			s := step
+			if s <= 0 {
+				s = abs(*min) / 2
+			}
+			out.Min = format(*min - maxFloat(s, 1))
+		}
+		if max != nil {
+			s := step
+			if s <= 0 {
+				s = abs(*max) / 2
+			}
+			out.Max = format(*max + maxFloat(s, 1))
+		}
+		return &out, true
+	}
+
+	var t1, t2 time.Time
+	if rng.Min != "" {
+		if t1, _ = parseTime(rng.Min, rp.loc); t1.IsZero() {
+			return nil, false
+		}
+	}
+	if rng.Max != "" {
+		if t2, _ = parseTime(rng.Max, rp.loc); t2.IsZero() {
+			return nil, false
+		}
+	}
+	const day = "2006-01-02"
+	if !t1.IsZero() && !t2.IsZero() {
+		width := t2.Sub(t1)
+		if width < 24*time.Hour {
+			width = 24 * time.Hour
+		}
+		out.Min, out.Max = t1.Add(-width).Format(day), t2.Add(width).Format(day)
+		return &out, true
+	}
+	if !t1.IsZero() {
+		out.Min = t1.AddDate(-1, 0, 0).Format(day)
+	}
+	if !t2.IsZero() {
+		out.Max = t2.AddDate(1, 0, 0).Format(day)
+	}
+	return &out, true
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// relaxScan lists the parts of a tree which can be relaxed. Only the
+// parts which count towards a match (not negated) are included.
+type relaxScan struct {
+	// required holds the required clauses of each list or AND expression
+	required [][]weighted
+	// ands holds the AND expressions, and lists with required clauses
+	ands   []Node
+	terms  []*Term
+	ranges []fieldRange
+}
+
+type weighted struct {
+	clause Node
+	weight float64
+}
+
+type fieldRange struct {
+	r     *RangeExpr
+	field string
+}
+
+func (r *relaxer) scan() *relaxScan {
+	s := &relaxScan{}
+	r.scanNode(s, r.tree, "", false)
+	return s
+}
+
+func (r *relaxer) scanNode(s *relaxScan, n Node, field string, neg bool) {
+	switch n := n.(type) {
+	case *List:
+		var required []weighted
+		for _, clause := range n.Clauses {
+			pe, ok := clause.(*PrefixExpr)
+			if (ok && pe.Op == '+') || (!ok && !negated(clause) && r.p.DefaultOp == AND) {
+				required = append(required, weighted{clause, weight(clause)})
+			}
+			r.scanNode(s, clause, field, neg)
+		}
+		if !neg && len(required) > 1 {
+			s.required = append(s.required, required)
+			s.ands = append(s.ands, n)
+		}
+	case *BoolExpr:
+		if !neg && n.Op == AND {
+			var required []weighted
+			for _, operand := range n.Operands {
+				if !negated(operand) {
+					required = append(required, weighted{operand, weight(operand)})
+				}
+			}
+			if len(required) > 1 {
+				s.required = append(s.required, required)
+				s.ands = append(s.ands, n)
+			}
+		}
+		for _, operand := range n.Operands {
+			r.scanNode(s, operand, field, neg)
+		}
+	case *NotExpr:
+		r.scanNode(s, n.X, field, !neg)
+	case *PrefixExpr:
+		r.scanNode(s, n.X, field, neg != (n.Op == '-'))
+	case *FieldExpr:
+		r.scanNode(s, n.X, n.Field, neg)
+	case *BoostExpr:
+		r.scanNode(s, n.X, field, neg)
+	case *Group:
+		r.scanNode(s, n.X, field, neg)
+	case *Term:
+		if !neg && !n.Fuzzy && !containsWildcard(n.Text) && utf8.RuneCountInString(n.Text) >= 4 {
+			if _, err := strconv.ParseFloat(n.Text, 64); err != nil {
+				s.terms = append(s.terms, n)
+			}
+		}
+	case *RangeExpr:
+		if !neg {
+			s.ranges = append(s.ranges, fieldRange{n, field})
+		}
+	}
+	// macro expansions are left as they are
+}
+
+// weight returns the boost of a clause
+func weight(n Node) float64 {
+	if pe, ok := n.(*PrefixExpr); ok {
+		n = pe.X
+	}
+	if b, ok := n.(*BoostExpr); ok {
+		return b.Boost
+	}
+	return 1
+}
@@ -0,0 +1,82 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve"
+	"reflect"
+	"testing"
+)
+
+func TestRelax(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "lemon tart", "tags": "citrus", "price": 12, "date": "2015-03-01T00:00:00Z"},
+		"2": {"title": "lime pickle", "tags": "citrus", "price": 5, "date": "2016-06-01T00:00:00Z"},
+		"3": {"title": "yellow paint", "tags": "paint", "price": 30, "date": "2017-01-01T00:00:00Z"},
+	}
+	for id, doc := range docs {
+		if err := idx.Index(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		op    OpType
+		q     string
+		relax string
+		total uint64
+		steps []string
+	}{
+		{OR, `lemon`, `lemon`, 1, nil},
+		{OR, `+lemon +pickle^0.5 +tart`, `+lemon +tart`, 1,
+			[]string{"dropped `+pickle^0.5`"}},
+		{OR, `lemon AND pickle`, `lemon OR pickle`, 2,
+			[]string{"changed `lemon AND pickle` to `lemon OR pickle`"}},
+		{AND, `lemon pickle -yellow`, `lemon OR pickle -yellow`, 2,
+			[]string{"changed `lemon pickle -yellow` to `lemon OR pickle -yellow`"}},
+		{OR, `lemmon`, `lemmon~1`, 1,
+			[]string{"allowed misspellings of `lemmon`"}},
+		// negated terms aren't touched
+		{OR, `pikle -lemmon`, `pikle~1 -lemmon`, 1,
+			[]string{"allowed misspellings of `pikle`"}},
+		// exclusions stay required
+		{AND, `pickle tart NOT lemon`, `pickle OR tart NOT lemon`, 1,
+			[]string{"changed `pickle tart NOT lemon` to `pickle OR tart NOT lemon`"}},
+		{OR, `pickle AND tart AND NOT lemon`, `(pickle OR tart) AND NOT lemon`, 1,
+			[]string{"changed `pickle AND tart AND NOT lemon` to `(pickle OR tart) AND NOT lemon`"}},
+		{OR, `pickle AND tart AND -lemon`, `(pickle OR tart) AND -lemon`, 1,
+			[]string{"changed `pickle AND tart AND -lemon` to `(pickle OR tart) AND -lemon`"}},
+		{AND, `kiwi AND NOT lemon`, `kiwi~1 AND NOT lemon`, 0,
+			[]string{"allowed misspellings of `kiwi`"}},
+		{OR, `price:[14 TO 16]`, `price:[12 TO 18]`, 1,
+			[]string{"widened `price:[14 TO 16]` to `price:[12 TO 18]`"}},
+		{OR, `price:>40`, `price:>20`, 1,
+			[]string{"widened `price:>40` to `price:>20`"}},
+		// nothing helps
+		{OR, `date:>=2018-01-01 -citrus -paint`, `date:>=2015-01-01 -citrus -paint`, 0,
+			[]string{
+				"widened `date:>=2018-01-01` to `date:>=2017-01-01`",
+				"widened `date:>=2017-01-01` to `date:>=2016-01-01`",
+				"widened `date:>=2016-01-01` to `date:>=2015-01-01`",
+			}},
+	}
+	for _, test := range tests {
+		p := Parser{DefaultOp: test.op}
+		r, err := p.Relax(idx, test.q)
+		if err != nil {
+			t.Errorf("`%s`: %s", test.q, err)
+			continue
+		}
+		if r.Query != test.relax || r.Total != test.total || !reflect.DeepEqual(r.Steps, test.steps) {
+			t.Errorf("`%s`: expected `%s` (%d hits) %q, got `%s` (%d hits) %q",
+				test.q, test.relax, test.total, test.steps, r.Query, r.Total, r.Steps)
+		}
+	}
+
+	if _, err := Relax(idx, `(lemon`); err == nil {
+		t.Errorf("expected error")
+	}
+}
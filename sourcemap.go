@@ -0,0 +1,273 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SourceMap records which part of a query string each query in a compiled
+// bleve query tree came from, so that things which point at a sub-query
+// (eg a score explanation) can be traced back to what the user typed.
+type SourceMap struct {
+	// Source is the query string
+	Source string
+	spans  map[query.Query]Span
+}
+
+// ParseWithSourceMap is like Parse, but also returns a SourceMap for the
+// returned query, using the default Parser.
+func ParseWithSourceMap(q string) (query.Query, *SourceMap, error) {
+	p := Parser{DefaultOp: OR}
+	return p.ParseWithSourceMap(q)
+}
+
+// ParseWithSourceMap is like Parse, but also returns a SourceMap giving
+// the span of q which produced each query in the returned tree. A query
+// maps to the largest part of the source which compiled to it, eg for
+// `title:(lemon)` the term query maps to the whole thing. Queries from
+// macro expansions map to the macro reference.
+//
+// The map is keyed on the queries themselves, so the Cache isn't used.
+func (p *Parser) ParseWithSourceMap(q string) (query.Query, *SourceMap, error) {
+	tree, err := p.ParseTree(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	sm := &SourceMap{Source: q, spans: map[query.Query]Span{}}
+	out, err := p.compile(tree, context{srcmap: sm})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, sm, nil
+}
+
+// record notes that q was compiled from n, replacing any span from a node
+// within n
+func (sm *SourceMap) record(q query.Query, n Node) {
+	span := Span{n.Pos(), n.End()}
+	sm.spans[q] = span
+	// the containers BooleanQuery builds for its clauses come from the
+	// same place
+	if b, ok := q.(*query.BooleanQuery); ok {
+		for _, sub := range []query.Query{b.Must, b.Should, b.MustNot} {
+			if sub != nil {
+				sm.spans[sub] = span
+			}
+		}
+	}
+}
+
+// Span returns the part of the source which produced q. q must be one of
+// the queries in the tree returned along with the SourceMap (not a copy).
+func (sm *SourceMap) Span(q query.Query) (Span, bool) {
+	span, ok := sm.spans[q]
+	return span, ok
+}
+
+// Text returns the source text which produced q, or "" if q isn't known.
+func (sm *SourceMap) Text(q query.Query) string {
+	span, ok := sm.spans[q]
+	if !ok {
+		return ""
+	}
+	return sm.Source[span.From:span.To]
+}
+
+// Annotate returns a copy of a bleve score explanation with the source of
+// each part added to its message, eg:
+//
+//   weight(title:lemon^1.000000 in doc3), product of: [title:lemon at 0-11]
+//
+// Explanations don't refer to queries, so the sources of term weights are
+// found by field and term, and the parts made up of several weights are
+// given the span covering all of theirs. As terms in an explanation have
+// been through text analysis, the matching is approximate: words are
+// compared ignoring case, and a term on a field with a range query is put
+// down to the range if nothing else matches it.
+func (sm *SourceMap) Annotate(expl *search.Explanation) *search.Explanation {
+	if expl == nil {
+		return nil
+	}
+	out, _ := sm.annotate(expl, sm.termSources())
+	return out
+}
+
+func (sm *SourceMap) annotate(expl *search.Explanation, sources []termSource) (*search.Explanation, []Span) {
+	out := &search.Explanation{Value: expl.Value, Message: expl.Message}
+	var spans []Span
+	if field, term, ok := weightTerm(expl.Message); ok {
+		spans = matchTerm(sources, field, term)
+		// the parts of a weight are about the same term
+		for _, child := range expl.Children {
+			out.Children = append(out.Children, copyExplanation(child))
+		}
+	} else {
+		for _, child := range expl.Children {
+			c, childSpans := sm.annotate(child, sources)
+			out.Children = append(out.Children, c)
+			spans = append(spans, childSpans...)
+		}
+		if len(spans) > 1 {
+			spans = []Span{covering(spans)}
+		}
+	}
+	if len(spans) > 0 {
+		parts := make([]string, len(spans))
+		for i, span := range spans {
+			parts[i] = fmt.Sprintf("%s at %d-%d", sm.Source[span.From:span.To], span.From, span.To)
+		}
+		out.Message += " [" + strings.Join(parts, ", ") + "]"
+	}
+	return out, spans
+}
+
+func copyExplanation(expl *search.Explanation) *search.Explanation {
+	out := &search.Explanation{Value: expl.Value, Message: expl.Message}
+	for _, child := range expl.Children {
+		out.Children = append(out.Children, copyExplanation(child))
+	}
+	return out
+}
+
+// weightTerm picks the field and term out of a term weight explanation,
+// eg "weight(title:lemon^1.000000 in doc3), product of:" or
+// "fieldWeight(title:lemon in doc3), product of:"
+func weightTerm(msg string) (string, string, bool) {
+	var end int
+	switch {
+	case strings.HasPrefix(msg, "weight("):
+		msg = msg[len("weight("):]
+		end = strings.LastIndex(msg, "^")
+	case strings.HasPrefix(msg, "fieldWeight("):
+		msg = msg[len("fieldWeight("):]
+		end = strings.LastIndex(msg, " in ")
+	default:
+		return "", "", false
+	}
+	colon := strings.Index(msg, ":")
+	if end < 0 || colon < 0 || colon > end {
+		return "", "", false
+	}
+	return msg[:colon], msg[colon+1 : end], true
+}
+
+// covering returns the smallest span containing all of spans
+func covering(spans []Span) Span {
+	out := spans[0]
+	for _, span := range spans[1:] {
+		if span.From < out.From {
+			out.From = span.From
+		}
+		if span.To > out.To {
+			out.To = span.To
+		}
+	}
+	return out
+}
+
+// termSource is a query which could be responsible for matching terms
+type termSource struct {
+	field string // "" matches any field
+	match func(term string) bool
+	span  Span
+	// isRange is set for ranges, which are only used if nothing else
+	// matches (numeric and date terms are encoded in the index)
+	isRange bool
+}
+
+// termSources lists the term-level queries in the map
+func (sm *SourceMap) termSources() []termSource {
+	var out []termSource
+	for q, span := range sm.spans {
+		src := termSource{span: span}
+		switch q := q.(type) {
+		case *query.MatchPhraseQuery:
+			words := strings.FieldsFunc(strings.ToLower(q.MatchPhrase), func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+			})
+			src.field = q.FieldVal
+			src.match = func(term string) bool {
+				for _, w := range words {
+					if w == term {
+						return true
+					}
+				}
+				return false
+			}
+		case *query.WildcardQuery:
+			re, err := regexp.Compile("^" + wildcardRegexp(strings.ToLower(q.Wildcard)) + "$")
+			if err != nil {
+				continue
+			}
+			src.field = q.FieldVal
+			src.match = re.MatchString
+		case *query.FuzzyQuery:
+			text, fuzziness := strings.ToLower(q.Term), q.Fuzziness
+			src.field = q.FieldVal
+			src.match = func(term string) bool {
+				_, ok := search.LevenshteinDistanceMax(text, term, fuzziness)
+				return !ok
+			}
+		case *TermSetQuery:
+			src.field = q.FieldVal
+			src.match = q.has
+		case *query.TermRangeQuery:
+			min, max := q.Min, q.Max
+			src.field, src.isRange = q.FieldVal, true
+			src.match = func(term string) bool {
+				return (min == "" || term >= min) && (max == "" || term <= max)
+			}
+		case *query.NumericRangeQuery:
+			src.field, src.isRange = q.FieldVal, true
+		case *query.DateRangeQuery:
+			src.field, src.isRange = q.FieldVal, true
+		default:
+			continue
+		}
+		out = append(out, src)
+	}
+	return out
+}
+
+// matchTerm returns the spans of the sources which could have matched a
+// term, in order
+func matchTerm(sources []termSource, field, term string) []Span {
+	var spans, ranges []Span
+	for _, src := range sources {
+		if src.field != "" && src.field != field {
+			continue
+		}
+		switch {
+		case src.match != nil && src.match(term):
+			spans = append(spans, src.span)
+		case src.isRange:
+			ranges = append(ranges, src.span)
+		}
+	}
+	if len(spans) == 0 {
+		spans = ranges
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].From < spans[j].From })
+	return spans
+}
+
+// wildcardRegexp converts a wildcard pattern into a regular expression
+func wildcardRegexp(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
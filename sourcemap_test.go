@@ -0,0 +1,96 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"strings"
+	"testing"
+)
+
+func TestSourceMap(t *testing.T) {
+	p := Parser{Macros: testMacros}
+	q, sm, err := p.ParseWithSourceMap(`title:(lemon) -lime @citrus_fruits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := q.(*query.BooleanQuery)
+	should := b.Should.(*query.DisjunctionQuery).Disjuncts
+	mustNot := b.MustNot.(*query.DisjunctionQuery).Disjuncts
+	macro := should[1].(*query.DisjunctionQuery)
+	for _, test := range []struct {
+		q    query.Query
+		text string
+	}{
+		{q, `title:(lemon) -lime @citrus_fruits`},
+		{b.Should, `title:(lemon) -lime @citrus_fruits`},
+		{should[0], `title:(lemon)`},
+		{mustNot[0], `lime`},
+		{macro, `@citrus_fruits`},
+		// positions inside the expansion aren't in the source
+		{macro.Disjuncts[1], `@citrus_fruits`},
+	} {
+		if got := sm.Text(test.q); got != test.text {
+			t.Errorf("%T: expected `%s`, got `%s`", test.q, test.text, got)
+		}
+	}
+	if _, ok := sm.Span(bleve.NewMatchQuery("lemon")); ok {
+		t.Errorf("unexpected span for unknown query")
+	}
+
+	if _, _, err := ParseWithSourceMap(`title:(lemon`); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestSourceMapAnnotate(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if err := idx.Index("doc1", map[string]interface{}{"title": "Lemon tart", "body": "zesty", "price": 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	const src = `title:LEMON^2 zest* price:<10`
+	q, sm, err := ParseWithSourceMap(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := bleve.NewSearchRequest(q)
+	req.Explain = true
+	res, err := idx.Search(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 {
+		t.Fatalf("expected 1 hit, got %d", len(res.Hits))
+	}
+	expl := sm.Annotate(res.Hits[0].Expl)
+
+	var messages []string
+	var walk func(*search.Explanation)
+	walk = func(e *search.Explanation) {
+		messages = append(messages, e.Message)
+		for _, child := range e.Children {
+			walk(child)
+		}
+	}
+	walk(expl)
+	all := strings.Join(messages, "\n")
+	for _, want := range []string{
+		"weight(title:lemon^1.000000 in doc1), product of: [title:LEMON^2 at 0-13]",
+		"weight(_all:zesty^1.000000 in doc1), product of: [zest* at 14-19]",
+		" [price:<10 at 20-29]",
+		"[" + src + " at 0-29]",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected %q in:\n%s", want, all)
+		}
+	}
+	// the original is untouched
+	if strings.Contains(res.Hits[0].Expl.Message, "[") {
+		t.Errorf("original explanation modified")
+	}
+}
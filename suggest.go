@@ -0,0 +1,232 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Suggestion is a spelling-corrected version of a query, as returned by
+// Suggest.
+type Suggestion struct {
+	// Query is the corrected query string (the original, if there's
+	// nothing to correct)
+	Query string
+	// Corrections lists the terms which were changed, in order
+	Corrections []Correction
+}
+
+// Correction is a term replaced by Suggest.
+type Correction struct {
+	// Span locates the term in the original query string
+	Span  Span
+	Field string
+	// Original is the term as written, and Replacement is what it was
+	// changed to
+	Original, Replacement string
+	// DocFreq is the number of documents containing Replacement
+	DocFreq uint64
+}
+
+// Suggest looks for misspelt terms in a query, using the default Parser.
+// See Parser.Suggest.
+func Suggest(idx bleve.Index, q string) (*Suggestion, error) {
+	p := Parser{DefaultOp: OR}
+	return p.Suggest(idx, q)
+}
+
+// Suggest checks each plain term of a query which counts towards a match
+// against the terms in its field of an index, and for any which aren't
+// there, proposes the closest term which is. Candidates are within one
+// edit (for terms of up to four letters) or two, and are ranked by
+// document frequency, with each extra edit counting as a factor of ten. So
+// a term one edit away is preferred, unless one two edits away is in ten
+// times as many documents.
+//
+// The corrected query is the original string with just the misspelt terms
+// replaced, so the structure, operators, fields and formatting are kept
+// as written. The case of the original term is kept where it's simply
+// capitalised or all upper case (Lemmon => Lemon). Replacements come from
+// the index as analysed, so with a stemming analyser they'll be stems.
+//
+// Unfielded terms are checked against the default search field of the
+// Parser's Mapping, or if that isn't set, the index's mapping. Negated,
+// quoted, wildcard and fuzzy terms, numbers, and terms inside macro
+// expansions are left alone. Each field's term dictionary is scanned for
+// candidates, so this is best kept for queries which found nothing.
+//
+// Returned errors are from parsing q or reading the index.
+func (p *Parser) Suggest(idx bleve.Index, q string) (*Suggestion, error) {
+	tree, err := p.ParseTree(q)
+	if err != nil {
+		return nil, err
+	}
+	m := p.Mapping
+	if m == nil {
+		m = idx.Mapping()
+	}
+	// every occurrence of a term is needed, not just the first
+	c := termCollector{p: p, defaultFields: []string{m.DefaultSearchField()}, out: FieldTerms{}}
+	if err := c.collect(tree, "", false); err != nil {
+		return nil, err
+	}
+	terms := c.out
+
+	// positions within macro expansions are relative to the macro
+	inMacro := map[Node]bool{}
+	Inspect(tree, func(n Node) bool {
+		if macro, ok := n.(*MacroExpr); ok {
+			Inspect(macro.X, func(n Node) bool {
+				inMacro[n] = true
+				return true
+			})
+			return false
+		}
+		return true
+	})
+
+	sg := suggester{idx: idx, done: map[[2]string]suggestion{}}
+	var corrections []Correction
+	for _, field := range terms.Fields() {
+		for _, ht := range terms[field] {
+			t, ok := ht.Node.(*Term)
+			if !ok || ht.Kind != WordTerm || inMacro[t] {
+				continue
+			}
+			if _, err := strconv.ParseFloat(t.Text, 64); err == nil {
+				continue
+			}
+			word := analyzeTerm(m, field, t.Text)
+			if word == "" {
+				continue
+			}
+			best, freq, err := sg.correct(field, word)
+			if err != nil {
+				return nil, err
+			}
+			if best == "" {
+				continue
+			}
+			corrections = append(corrections, Correction{
+				Span:        t.Span,
+				Field:       field,
+				Original:    t.Text,
+				Replacement: matchCase(best, t.Text),
+				DocFreq:     freq,
+			})
+		}
+	}
+
+	sort.Slice(corrections, func(i, j int) bool { return corrections[i].Span.From < corrections[j].Span.From })
+	var sb strings.Builder
+	last := 0
+	for _, c := range corrections {
+		sb.WriteString(q[last:c.Span.From])
+		sb.WriteString(Format(&Term{Text: c.Replacement}))
+		last = c.Span.To
+	}
+	sb.WriteString(q[last:])
+	return &Suggestion{Query: sb.String(), Corrections: corrections}, nil
+}
+
+// analyzeTerm returns a term as it would be indexed in a field, or "" if
+// analysis doesn't leave a single term (eg for a stop word)
+func analyzeTerm(m mapping.IndexMapping, field, text string) string {
+	analyzer := m.AnalyzerNamed(m.AnalyzerNameForPath(field))
+	if analyzer == nil {
+		return strings.ToLower(text)
+	}
+	tokens := analyzer.Analyze([]byte(text))
+	if len(tokens) != 1 {
+		return ""
+	}
+	return string(tokens[0].Term)
+}
+
+type suggester struct {
+	idx bleve.Index
+	// done holds the replacements already worked out, by field and term
+	done map[[2]string]suggestion
+}
+
+type suggestion struct {
+	term string
+	freq uint64
+}
+
+// correct returns the best replacement for a term which isn't in a field,
+// along with its document frequency. Returns "" if the term is fine, or
+// there's nothing close.
+func (sg *suggester) correct(field, word string) (string, uint64, error) {
+	key := [2]string{field, word}
+	if s, ok := sg.done[key]; ok {
+		return s.term, s.freq, nil
+	}
+	best, freq, err := sg.search(field, word)
+	if err != nil {
+		return "", 0, err
+	}
+	sg.done[key] = suggestion{best, freq}
+	return best, freq, nil
+}
+
+// search scans a field's terms for the best replacement for word
+func (sg *suggester) search(field, word string) (string, uint64, error) {
+	maxEdits := 2
+	if utf8.RuneCountInString(word) <= 4 {
+		maxEdits = 1
+	}
+	dict, err := sg.idx.FieldDict(field)
+	if err != nil {
+		return "", 0, err
+	}
+	defer dict.Close()
+	var best string
+	var bestFreq uint64
+	bestScore := 0.0
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return "", 0, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Term == word {
+			return "", 0, nil
+		}
+		d, over := search.LevenshteinDistanceMax(word, entry.Term, maxEdits)
+		if over || d > maxEdits {
+			continue
+		}
+		score := float64(entry.Count) / math.Pow(10, float64(d))
+		if score > bestScore || (score == bestScore && entry.Term < best) {
+			best, bestFreq, bestScore = entry.Term, entry.Count, score
+		}
+	}
+	return best, bestFreq, nil
+}
+
+// matchCase gives a replacement the same capitalisation as the original,
+// if it's all upper case or starts with a capital
+func matchCase(replacement, original string) string {
+	switch {
+	case strings.ToUpper(original) == original && strings.ToLower(original) != original:
+		return strings.ToUpper(replacement)
+	case startsUpper(original):
+		r, size := utf8.DecodeRuneInString(replacement)
+		return string(unicode.ToUpper(r)) + replacement[size:]
+	}
+	return replacement
+}
+
+func startsUpper(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsUpper(r)
+}
@@ -0,0 +1,67 @@
+package qs
+
+import (
+	"github.com/blevesearch/bleve"
+	"reflect"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	idx, err := bleve.NewMemOnly(bleve.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	docs := map[string]map[string]interface{}{
+		"1": {"title": "Lemon tart", "tags": "citrus"},
+		"2": {"title": "Lemon drizzle cake", "tags": "citrus"},
+		"3": {"title": "Melon salad", "tags": "fruit"},
+		"4": {"title": "Lime pickle", "tags": "citrus"},
+		"5": {"title": "Demon drink", "tags": "gin"},
+	}
+	for id, doc := range docs {
+		if err := idx.Index(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q       string
+		expect  string
+		changed []string
+	}{
+		{`lemon tart`, `lemon tart`, nil},
+		// layout is kept
+		{`Lemmon   AND  title:(drizle^2 OR cake)`, `Lemon   AND  title:(drizzle^2 OR cake)`, []string{"Lemmon", "drizle"}},
+		{`tags:citris OR LEMN`, `tags:citrus OR LEMON`, []string{"citris", "LEMN"}},
+		// every occurrence
+		{`pickel OR title:pickel`, `pickle OR title:pickle`, []string{"pickel", "pickel"}},
+		// melon is closest, then lemon is in more documents than demon
+		{`lmelon pemon`, `melon lemon`, []string{"lmelon", "pemon"}},
+		// negated, quoted, wildcard, fuzzy and numeric terms are left alone
+		{`-lemn "lemn" lem*n lemn~1 42`, `-lemn "lemn" lem*n lemn~1 42`, nil},
+		// nothing close
+		{`zzzzzz`, `zzzzzz`, nil},
+	}
+	for _, test := range tests {
+		s, err := Suggest(idx, test.q)
+		if err != nil {
+			t.Errorf("`%s`: %s", test.q, err)
+			continue
+		}
+		var changed []string
+		for _, c := range s.Corrections {
+			if test.q[c.Span.From:c.Span.To] != c.Original {
+				t.Errorf("`%s`: bad span %v for %s", test.q, c.Span, c.Original)
+			}
+			changed = append(changed, c.Original)
+		}
+		if s.Query != test.expect || !reflect.DeepEqual(changed, test.changed) {
+			t.Errorf("`%s`: expected `%s` %q, got `%s` %q", test.q, test.expect, test.changed, s.Query, changed)
+		}
+	}
+
+	if _, err := Suggest(idx, `(lemn`); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,319 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve/search/query"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Prepared queries.
+//
+// A template is a query containing $name placeholders, eg:
+//
+//   author:$author AND pubdate:[$from TO $to] AND NOT status:$excluded
+//
+// It is parsed once, then bound to values as often as needed. Bound values
+// are inserted directly into the syntax tree rather than into the query
+// string, so they are always treated as plain values - nothing in them can
+// change the structure of the query.
+//
+// Placeholders can be used wherever a term can, and as range endpoints.
+// To search for a term or range endpoint which starts with '$', quote it.
+
+// Template is a parsed query containing parameter placeholders.
+// It is safe to bind a Template concurrently.
+type Template struct {
+	tree   Node
+	p      Parser
+	names  []string
+	terms  map[*Term]string
+	ranges map[*RangeExpr][2]string // min and max param names (or "")
+}
+
+// ParseTemplate parses a query template using the default Parser settings.
+func ParseTemplate(q string) (*Template, error) {
+	var p Parser
+	return p.ParseTemplate(q)
+}
+
+// ParseTemplate parses a query template. Queries built from the template
+// use the Parser's settings as they are now.
+func (p *Parser) ParseTemplate(q string) (*Template, error) {
+	tree, err := p.ParseTree(q)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{
+		tree:   tree,
+		p:      *p,
+		terms:  map[*Term]string{},
+		ranges: map[*RangeExpr][2]string{},
+	}
+	seen := map[string]bool{}
+	use := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			t.names = append(t.names, name)
+		}
+	}
+	Inspect(tree, func(n Node) bool {
+		switch n := n.(type) {
+		case *Term:
+			if name, ok := paramName(n.Text); ok {
+				if n.Fuzzy {
+					err = ParseError{n.Pos(), fmt.Sprintf("parameter $%s can't be fuzzy", name)}
+					return false
+				}
+				t.terms[n] = name
+				use(name)
+			}
+		case *RangeExpr:
+			// quoted endpoints are values, as with terms
+			var minName, maxName string
+			if !n.MinQuoted {
+				minName, _ = paramName(n.Min)
+			}
+			if !n.MaxQuoted {
+				maxName, _ = paramName(n.Max)
+			}
+			if minName != "" || maxName != "" {
+				t.ranges[n] = [2]string{minName, maxName}
+				if minName != "" {
+					use(minName)
+				}
+				if maxName != "" {
+					use(maxName)
+				}
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// paramName returns the name of the parameter if s is a placeholder
+// ($ followed by letters, digits or underscores).
+func paramName(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '$' {
+		return "", false
+	}
+	for i, r := range s[1:] {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return "", false
+		}
+	}
+	return s[1:], true
+}
+
+// Params returns the names of the template's parameters (without the
+// '$'), in order of first appearance.
+func (t *Template) Params() []string {
+	return append([]string(nil), t.names...)
+}
+
+// String returns the template in canonical form.
+func (t *Template) String() string {
+	return Format(t.tree)
+}
+
+// Bind substitutes values for the template's parameters and returns the
+// resulting syntax tree. Every parameter must be given a value, and every
+// value must be used by a parameter.
+//
+// In place of a term, a parameter can take a string, a number, a time.Time
+// (matched as a YYYY-MM-DD term) or a slice of those, which matches any of
+// its elements. As a range endpoint it takes a single string, number or
+// time.Time (see RangeValue); a number and a date can't be mixed in the
+// same range.
+//
+// Errors are type ParseError, positioned at the offending placeholder (or
+// for a value with no placeholder, at the end of the query).
+func (t *Template) Bind(args map[string]interface{}) (Node, error) {
+	for _, name := range sortedParams(args) {
+		if !t.hasParam(name) {
+			return nil, ParseError{t.tree.End(), fmt.Sprintf("unknown parameter $%s", name)}
+		}
+	}
+
+	var err error
+	fail := func(pos int, format string, a ...interface{}) Node {
+		if err == nil {
+			err = ParseError{pos, fmt.Sprintf(format, a...)}
+		}
+		return nil
+	}
+	bound := Rewrite(t.tree, func(n Node) Node {
+		switch n := n.(type) {
+		case *Term:
+			name, ok := t.terms[n]
+			if !ok {
+				return n
+			}
+			v, ok := args[name]
+			if !ok {
+				return fail(n.Pos(), "unbound parameter $%s", name)
+			}
+			x, msg := bindTerm(v)
+			if msg != "" {
+				return fail(n.Pos(), "parameter $%s: %s", name, msg)
+			}
+			setSpan(x, n.Span)
+			return x
+		case *RangeExpr:
+			names, ok := t.ranges[n]
+			if !ok {
+				return n
+			}
+			r := *n
+			var kinds [2]string
+			for i, name := range names {
+				if name == "" {
+					continue
+				}
+				v, ok := args[name]
+				if !ok {
+					return fail(n.Pos(), "unbound parameter $%s", name)
+				}
+				val, kind, msg := bindRangeValue(v)
+				if msg != "" {
+					return fail(n.Pos(), "parameter $%s: %s", name, msg)
+				}
+				kinds[i] = kind
+				if i == 0 {
+					r.Min = val
+				} else {
+					r.Max = val
+				}
+			}
+			// strings are up to the caller, but numbers and dates don't mix
+			if kinds[0] != kinds[1] && kinds[0] != "" && kinds[0] != "string" && kinds[1] != "" && kinds[1] != "string" {
+				return fail(n.Pos(), "parameters $%s and $%s have different types (%s and %s)", names[0], names[1], kinds[0], kinds[1])
+			}
+			return &r
+		}
+		return n
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bound, nil
+}
+
+// Query binds the parameters (see Bind) and compiles the result into a
+// bleve query.
+func (t *Template) Query(args map[string]interface{}) (query.Query, error) {
+	tree, err := t.Bind(args)
+	if err != nil {
+		return nil, err
+	}
+	return t.p.Compile(tree)
+}
+
+func (t *Template) hasParam(name string) bool {
+	for _, n := range t.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bindTerm builds the node for a value used in place of a term.
+// Returns a message describing the problem if the value isn't suitable.
+func bindTerm(v interface{}) (Node, string) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		if rv.Len() == 0 {
+			return nil, "empty list"
+		}
+		operands := make([]Node, rv.Len())
+		for i := range operands {
+			text, msg := termValue(rv.Index(i).Interface())
+			if msg != "" {
+				return nil, msg
+			}
+			operands[i] = literal(text)
+		}
+		var x Node = operands[0]
+		if len(operands) > 1 {
+			x = &BoolExpr{Op: OR, Operands: operands}
+		}
+		return &Group{X: &List{Clauses: []Node{x}}}, ""
+	}
+	text, msg := termValue(v)
+	if msg != "" {
+		return nil, msg
+	}
+	return literal(text), ""
+}
+
+func termValue(v interface{}) (string, string) {
+	if s, ok := v.(string); ok && s == "" {
+		return "", "empty value"
+	}
+	text, err := RangeValue(v)
+	if err != nil {
+		return "", fmt.Sprintf("unsupported type %T", v)
+	}
+	return text, ""
+}
+
+// bindRangeValue converts a value for use as a range endpoint, also
+// returning what kind of value it is.
+func bindRangeValue(v interface{}) (string, string, string) {
+	var kind string
+	switch v.(type) {
+	case string:
+		kind = "string"
+	case time.Time:
+		kind = "date"
+	default:
+		if reflect.ValueOf(v).Kind() == reflect.Slice {
+			return "", "", fmt.Sprintf("expected a single value, got %T", v)
+		}
+		kind = "number"
+	}
+	val, err := RangeValue(v)
+	if err != nil {
+		return "", "", err.Error()
+	}
+	return val, kind, ""
+}
+
+// setSpan gives a bound node (and its descendants) the position of the
+// placeholder it replaces.
+func setSpan(n Node, span Span) {
+	Inspect(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *List:
+			n.Span = span
+		case *BoolExpr:
+			n.Span = span
+		case *Group:
+			n.Span = span
+		case *Term:
+			n.Span = span
+		case *Phrase:
+			n.Span = span
+		}
+		return true
+	})
+}
+
+// sortedParams is used for stable error messages
+func sortedParams(args map[string]interface{}) []string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,138 @@
+package qs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ExampleTemplate() {
+	tmpl, _ := ParseTemplate(`author:$author AND pubdate:[$from TO $to] AND NOT status:$excluded`)
+	fmt.Println(tmpl.Params())
+
+	tree, _ := tmpl.Bind(map[string]interface{}{
+		"author":   `Bobby "Tables" O'Brien`,
+		"from":     time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+		"to":       time.Date(2015, 12, 31, 0, 0, 0, 0, time.UTC),
+		"excluded": []string{"draft", "deleted) OR (x"},
+	})
+	fmt.Println(Format(tree))
+	// Output:
+	// [author from to excluded]
+	// author:"Bobby \"Tables\" O'Brien" AND pubdate:[2015-01-01 TO 2015-12-31] AND NOT status:(draft OR "deleted) OR (x")
+}
+
+func TestTemplateBind(t *testing.T) {
+	data := []struct {
+		tmpl     string
+		args     map[string]interface{}
+		expected string
+	}{
+		{`$a`, map[string]interface{}{"a": "grapefruit"}, `grapefruit`},
+		{`tags:$a^2 $a`, map[string]interface{}{"a": "navel orange"}, `tags:"navel orange"^2 "navel orange"`},
+		{`$a`, map[string]interface{}{"a": "OR"}, `"OR"`},
+		{`$a`, map[string]interface{}{"a": "f??t"}, `"f??t"`},
+		{`-$a`, map[string]interface{}{"a": []string{"x", "y z"}}, `-(x OR "y z")`},
+		{`$a`, map[string]interface{}{"a": []interface{}{"x", 2}}, `(x OR 2)`},
+		{`n:$a`, map[string]interface{}{"a": []int{7}}, `n:(7)`},
+		{`n:[$lo TO $hi}`, map[string]interface{}{"lo": 1, "hi": 2.5}, `n:[1 TO 2.5}`},
+		{`n:>$lo`, map[string]interface{}{"lo": -4}, `n:>"-4"`},
+		{`n:[$lo TO 10]`, map[string]interface{}{"lo": "5"}, `n:[5 TO 10]`},
+		{`"$a" $a`, map[string]interface{}{"a": "x"}, `"$a" x`},
+		{`$a $a*`, map[string]interface{}{"a": "x"}, `x $a*`},
+	}
+
+	for _, dat := range data {
+		tmpl, err := ParseTemplate(dat.tmpl)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.tmpl, err)
+		}
+		before := tmpl.String()
+		tree, err := tmpl.Bind(dat.args)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.tmpl, err)
+		}
+		got := Format(tree)
+		if got != dat.expected {
+			t.Errorf("`%s`: expected `%s`, got `%s`", dat.tmpl, dat.expected, got)
+			continue
+		}
+		if tmpl.String() != before {
+			t.Errorf("`%s`: template modified by Bind", dat.tmpl)
+		}
+
+		// should compile the same as the rendered query
+		expected, err := Parse(got)
+		if err != nil {
+			t.Fatalf("`%s`: %s", got, err)
+		}
+		q, err := tmpl.Query(dat.args)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.tmpl, err)
+		}
+		if !reflect.DeepEqual(q, expected) {
+			t.Errorf("`%s`: bound query differs from parsed `%s`", dat.tmpl, got)
+		}
+	}
+}
+
+// quoted range endpoints aren't parameters, as with terms
+func TestTemplateQuotedRange(t *testing.T) {
+	tmpl, err := ParseTemplate(`date:["$from" TO $to] n:>'$x'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tmpl.Params(); !reflect.DeepEqual(got, []string{"to"}) {
+		t.Errorf("expected [to], got %q", got)
+	}
+	tree, err := tmpl.Bind(map[string]interface{}{"to": "2015-01-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Format(tree); got != `date:["$from" TO 2015-01-01] n:>"$x"` {
+		t.Errorf("unexpected bound query `%s`", got)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	if _, err := ParseTemplate(`$a~2`); err == nil {
+		t.Errorf("expected error for fuzzy parameter")
+	}
+
+	date := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []struct {
+		tmpl string
+		args map[string]interface{}
+		pos  int
+	}{
+		{`foo $a`, map[string]interface{}{}, 4},
+		{`foo $a`, map[string]interface{}{"a": true}, 4},
+		{`foo $a`, map[string]interface{}{"a": ""}, 4},
+		{`foo $a`, map[string]interface{}{"a": []string{}}, 4},
+		{`foo $a`, map[string]interface{}{"a": []bool{true}}, 4},
+		{`foo n:[$a TO $b]`, map[string]interface{}{"a": 1}, 6},
+		{`foo n:[$a TO $b]`, map[string]interface{}{"a": 1, "b": []int{2}}, 6},
+		{`foo n:[$a TO $b]`, map[string]interface{}{"a": 1, "b": date}, 6},
+	}
+	for _, dat := range data {
+		tmpl, err := ParseTemplate(dat.tmpl)
+		if err != nil {
+			t.Fatalf("`%s`: %s", dat.tmpl, err)
+		}
+		_, err = tmpl.Query(dat.args)
+		if err == nil {
+			t.Errorf("`%s` %v: expected error", dat.tmpl, dat.args)
+			continue
+		}
+		if perr, ok := err.(ParseError); !ok || perr.Pos != dat.pos {
+			t.Errorf("`%s` %v: expected error at %d, got %v", dat.tmpl, dat.args, dat.pos, err)
+		}
+	}
+
+	tmpl, _ := ParseTemplate(`$a`)
+	_, err := tmpl.Bind(map[string]interface{}{"a": "x", "b": "y"})
+	if pe, ok := err.(ParseError); !ok || pe.Pos != 2 {
+		t.Errorf("expected ParseError for unknown parameter, got %v", err)
+	}
+}
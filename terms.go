@@ -0,0 +1,192 @@
+package qs
+
+import (
+	"fmt"
+	"github.com/blevesearch/bleve"
+	"sort"
+)
+
+// TermKind says how a HighlightTerm matches text.
+type TermKind int
+
+const (
+	WordTerm     TermKind = iota // a single word
+	PhraseTerm                   // a quoted phrase
+	WildcardTerm                 // a word containing * or ?
+	FuzzyTerm                    // a word, or anything within Fuzziness edits of it
+)
+
+func (k TermKind) String() string {
+	switch k {
+	case PhraseTerm:
+		return "phrase"
+	case WildcardTerm:
+		return "wildcard"
+	case FuzzyTerm:
+		return "fuzzy"
+	}
+	return "word"
+}
+
+// HighlightTerm is something in a query which contributes to a match.
+type HighlightTerm struct {
+	Kind      TermKind
+	Text      string
+	Fuzziness int // for FuzzyTerm
+	// Node is the part of the tree the term came from (nil for the terms
+	// of an @list lookup, which come from Parser.Lists)
+	Node Node
+}
+
+// FieldTerms holds the positive terms of a query, by field.
+type FieldTerms map[string][]HighlightTerm
+
+// Fields returns the fields which have terms, in order.
+func (ft FieldTerms) Fields() []string {
+	fields := make([]string, 0, len(ft))
+	for field := range ft {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// HighlightRequest returns a bleve HighlightRequest for the fields which
+// have terms. Terms with no known field are left out.
+func (ft FieldTerms) HighlightRequest() *bleve.HighlightRequest {
+	h := bleve.NewHighlight()
+	for _, field := range ft.Fields() {
+		if field != "" {
+			h.AddField(field)
+		}
+	}
+	return h
+}
+
+// PositiveTerms returns the terms of a syntax tree which contribute
+// positively to a match, using the default Parser. See
+// Parser.PositiveTerms.
+func PositiveTerms(tree Node, defaultFields ...string) (FieldTerms, error) {
+	p := Parser{DefaultOp: OR}
+	return p.PositiveTerms(tree, defaultFields...)
+}
+
+// PositiveTerms returns the terms, phrases, wildcards and fuzzy terms in
+// a syntax tree which contribute positively to a match, grouped by field,
+// for use in highlighting. Anything excluded by NOT or - is left out (but
+// NOT -x counts, as it matches x). Ranges aren't included.
+//
+// Terms which aren't in a field are listed under each of defaultFields,
+// or if there are none, under the Mapping's default search field, or
+// failing that, "".
+//
+// The terms of @list lookups are fetched from Parser.Lists, if set.
+// Returned errors come from fetching lists.
+func (p *Parser) PositiveTerms(tree Node, defaultFields ...string) (FieldTerms, error) {
+	if len(defaultFields) == 0 {
+		defaultFields = []string{""}
+		if p.Mapping != nil {
+			defaultFields[0] = p.Mapping.DefaultSearchField()
+		}
+	}
+	c := termCollector{p: p, defaultFields: defaultFields, out: FieldTerms{}, seen: map[seenTerm]bool{}}
+	if err := c.collect(tree, "", false); err != nil {
+		return nil, err
+	}
+	return c.out, nil
+}
+
+type termCollector struct {
+	p             *Parser
+	defaultFields []string
+	out           FieldTerms
+	// seen holds the terms collected so far, to skip duplicates (if nil,
+	// every occurrence is kept)
+	seen map[seenTerm]bool
+}
+
+type seenTerm struct {
+	field     string
+	kind      TermKind
+	text      string
+	fuzziness int
+}
+
+// collect gathers the terms of n. field is the field in scope (if any),
+// and neg is set if n is negated.
+func (c *termCollector) collect(n Node, field string, neg bool) error {
+	switch n := n.(type) {
+	case *List:
+		for _, clause := range n.Clauses {
+			if err := c.collect(clause, field, neg); err != nil {
+				return err
+			}
+		}
+	case *BoolExpr:
+		for _, operand := range n.Operands {
+			if err := c.collect(operand, field, neg); err != nil {
+				return err
+			}
+		}
+	case *NotExpr:
+		return c.collect(n.X, field, !neg)
+	case *PrefixExpr:
+		if n.Op == '-' {
+			return c.collect(n.X, field, !neg)
+		}
+		return c.collect(n.X, field, neg)
+	case *FieldExpr:
+		return c.collect(n.X, n.Field, neg)
+	case *BoostExpr:
+		return c.collect(n.X, field, neg)
+	case *Group:
+		return c.collect(n.X, field, neg)
+	case *MacroExpr:
+		return c.collect(n.X, field, neg)
+	case *Term:
+		if neg {
+			return nil
+		}
+		t := HighlightTerm{Kind: WordTerm, Text: n.Text, Node: n}
+		if containsWildcard(n.Text) {
+			t.Kind = WildcardTerm
+		} else if n.Fuzzy {
+			t.Kind, t.Fuzziness = FuzzyTerm, n.Fuzziness
+		}
+		c.add(field, t)
+	case *Phrase:
+		if !neg && n.Text != "" {
+			c.add(field, HighlightTerm{Kind: PhraseTerm, Text: n.Text, Node: n})
+		}
+	case *TermsLookup:
+		if neg || c.p.Lists == nil {
+			return nil
+		}
+		terms, err := c.p.Lists.TermList(n.Name)
+		if err != nil {
+			return ParseError{n.Pos(), fmt.Sprintf("list '%s': %s", n.Name, err)}
+		}
+		for _, term := range terms {
+			c.add(field, HighlightTerm{Kind: WordTerm, Text: term})
+		}
+	}
+	return nil
+}
+
+// add records a term, ignoring duplicates
+func (c *termCollector) add(field string, t HighlightTerm) {
+	fields := c.defaultFields
+	if field != "" {
+		fields = []string{field}
+	}
+	for _, f := range fields {
+		if c.seen != nil {
+			key := seenTerm{f, t.Kind, t.Text, t.Fuzziness}
+			if c.seen[key] {
+				continue
+			}
+			c.seen[key] = true
+		}
+		c.out[f] = append(c.out[f], t)
+	}
+}
@@ -0,0 +1,100 @@
+package qs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPositiveTerms(t *testing.T) {
+	data := []struct {
+		input    string
+		expected map[string]string
+	}{
+		{`lemon "navel orange" lem* lemon~2`, map[string]string{
+			"body":  `word:lemon phrase:navel orange wildcard:lem* fuzzy:lemon~2`,
+			"title": `word:lemon phrase:navel orange wildcard:lem* fuzzy:lemon~2`,
+		}},
+		{`title:(lemon -lime) -tags:paint NOT grapefruit`, map[string]string{
+			"title": `word:lemon`,
+		}},
+		{`a OR -b OR NOT c AND NOT -d`, map[string]string{
+			"body":  `word:a word:d`,
+			"title": `word:a word:d`,
+		}},
+		{`title:x^2 +title:(x y) date:>2015-01-01`, map[string]string{
+			"title": `word:x word:y`,
+		}},
+		{`-(a title:b) c`, map[string]string{
+			"body":  `word:c`,
+			"title": `word:c`,
+		}},
+	}
+	for _, dat := range data {
+		tree, err := ParseTree(dat.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		terms, err := PositiveTerms(tree, "body", "title")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		for field, ts := range terms {
+			parts := []string{}
+			for _, term := range ts {
+				s := term.Kind.String() + ":" + term.Text
+				if term.Kind == FuzzyTerm {
+					s += "~" + string(rune('0'+term.Fuzziness))
+				}
+				parts = append(parts, s)
+			}
+			got[field] = strings.Join(parts, " ")
+		}
+		if !reflect.DeepEqual(got, dat.expected) {
+			t.Errorf("`%s`: expected %v, got %v", dat.input, dat.expected, got)
+		}
+	}
+}
+
+func TestPositiveTermsMacros(t *testing.T) {
+	p := Parser{
+		Macros: testMacros,
+		Lists:  TermListMap{"ids": {"a1", "b2"}},
+	}
+	tree, err := p.ParseTree(`tags:@citrus_fruits -@discontinued id:@list(ids) -id:@list(nope)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms, err := p.PositiveTerms(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := terms.Fields(); !reflect.DeepEqual(got, []string{"id", "tags"}) {
+		t.Errorf("unexpected fields %v", got)
+	}
+	if len(terms["tags"]) != 3 || terms["tags"][0].Text != "lemon" || terms["tags"][0].Node == nil {
+		t.Errorf("unexpected tags terms %v", terms["tags"])
+	}
+	if len(terms["id"]) != 2 || terms["id"][1].Text != "b2" {
+		t.Errorf("unexpected id terms %v", terms["id"])
+	}
+
+	h := terms.HighlightRequest()
+	if !reflect.DeepEqual(h.Fields, []string{"id", "tags"}) {
+		t.Errorf("unexpected highlight fields %v", h.Fields)
+	}
+
+	tree, _ = p.ParseTree(`id:@list(nope)`)
+	if _, err := p.PositiveTerms(tree); err == nil {
+		t.Errorf("expected error for unknown list")
+	}
+
+	// unfielded terms go to the mapping's default field
+	p = Parser{Mapping: keywordMapping()}
+	tree, _ = p.ParseTree(`lemon`)
+	terms, _ = p.PositiveTerms(tree)
+	if got := terms.Fields(); !reflect.DeepEqual(got, []string{"_all"}) {
+		t.Errorf("unexpected fields %v", got)
+	}
+}
@@ -0,0 +1,148 @@
+package qs
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TokenKind identifies the type of a Token.
+type TokenKind int
+
+const (
+	TokError   TokenKind = iota // malformed input, eg an unclosed quote
+	TokSpace                    // whitespace between other tokens
+	TokLiteral                  // bare term, field name or range endpoint
+	TokQuoted                   // quoted phrase, quotes included
+	TokPlus                     // +
+	TokMinus                    // -
+	TokColon                    // :
+	TokEqual                    // =
+	TokGreater                  // >
+	TokLess                     // <
+	TokOr                       // OR
+	TokAnd                      // AND
+	TokNot                      // NOT
+	TokLParen                   // (
+	TokRParen                   // )
+	TokLSquare                  // [
+	TokRSquare                  // ]
+	TokLBrace                   // {
+	TokRBrace                   // }
+	TokTo                       // TO
+	TokBoost                    // ^ and optional number
+	TokFuzzy                    // ~ and optional number
+)
+
+var tokenKindNames = [...]string{
+	TokError:   "Error",
+	TokSpace:   "Space",
+	TokLiteral: "Literal",
+	TokQuoted:  "Quoted",
+	TokPlus:    "Plus",
+	TokMinus:   "Minus",
+	TokColon:   "Colon",
+	TokEqual:   "Equal",
+	TokGreater: "Greater",
+	TokLess:    "Less",
+	TokOr:      "Or",
+	TokAnd:     "And",
+	TokNot:     "Not",
+	TokLParen:  "LParen",
+	TokRParen:  "RParen",
+	TokLSquare: "LSquare",
+	TokRSquare: "RSquare",
+	TokLBrace:  "LBrace",
+	TokRBrace:  "RBrace",
+	TokTo:      "To",
+	TokBoost:   "Boost",
+	TokFuzzy:   "Fuzzy",
+}
+
+func (k TokenKind) String() string {
+	if k < 0 || int(k) >= len(tokenKindNames) {
+		return "TokenKind(?)"
+	}
+	return tokenKindNames[k]
+}
+
+// map internal lexer types to the public ones
+var tokenKinds = map[tokType]TokenKind{
+	tERROR:   TokError,
+	tLITERAL: TokLiteral,
+	tQUOTED:  TokQuoted,
+	tPLUS:    TokPlus,
+	tMINUS:   TokMinus,
+	tCOLON:   TokColon,
+	tEQUAL:   TokEqual,
+	tGREATER: TokGreater,
+	tLESS:    TokLess,
+	tOR:      TokOr,
+	tAND:     TokAnd,
+	tNOT:     TokNot,
+	tLPAREN:  TokLParen,
+	tRPAREN:  TokRParen,
+	tLSQUARE: TokLSquare,
+	tRSQUARE: TokRSquare,
+	tLBRACE:  TokLBrace,
+	tRBRACE:  TokRBrace,
+	tTO:      TokTo,
+	tBOOST:   TokBoost,
+	tFUZZY:   TokFuzzy,
+}
+
+// Token is a lexical element of a query string, as returned by Tokenize.
+type Token struct {
+	Kind TokenKind
+	// Text is the exact source text covered by the token.
+	Text string
+	// Pos is the byte offset of the start of the token, End the offset
+	// just past it.
+	Pos, End int
+	// Err describes the problem, for TokError tokens.
+	Err string
+}
+
+// Tokenize breaks a query string up into tokens, for use by syntax
+// highlighters, formatters and the like.
+//
+// Unlike the parser, Tokenize never fails. Whitespace is returned as
+// TokSpace tokens and anything the lexer can't make sense of as TokError
+// tokens, so concatenating the Text of all the returned tokens will always
+// reproduce the input exactly.
+func Tokenize(q string) []Token {
+	out := []Token{}
+	pos := 0
+	for _, tok := range lex(q) {
+		if tok.typ == tEOF {
+			break
+		}
+		if tok.pos < pos || tok.end <= tok.pos {
+			// empty (or overlapping) tokens can only follow an error
+			continue
+		}
+		if tok.pos > pos {
+			out = appendGap(out, q, pos, tok.pos)
+		}
+		t := Token{Kind: tokenKinds[tok.typ], Text: q[tok.pos:tok.end], Pos: tok.pos, End: tok.end}
+		if tok.typ == tERROR {
+			t.Err = tok.val
+		}
+		out = append(out, t)
+		pos = tok.end
+	}
+	if pos < len(q) {
+		out = appendGap(out, q, pos, len(q))
+	}
+	return out
+}
+
+// appendGap adds a token to cover input which the lexer skipped over.
+// Usually that's just whitespace.
+func appendGap(out []Token, q string, pos, end int) []Token {
+	t := Token{Kind: TokSpace, Text: q[pos:end], Pos: pos, End: end}
+	if strings.TrimFunc(t.Text, unicode.IsSpace) != "" {
+		t.Kind = TokError
+		t.Err = "unexpected input"
+	}
+	return append(out, t)
+}
@@ -0,0 +1,71 @@
+package qs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeRoundTrip(t *testing.T) {
+	inputs := []string{
+		``,
+		`   `,
+		`wibble`,
+		`  wibble   pibble  `,
+		`tags:(lemon mango) -"navel orange"^2`,
+		`date:[2014-01-01 TO 2014-01-02}`,
+		`shoesize:>=10`,
+		`wibble~1 fnord^`,
+		`"unclosed quote`,
+		`cat^3\:`,
+		`cat~3x0 dog`,
+		"tabs\tand\nnewlines",
+		`ünïcödé:"ßtrasse"`,
+	}
+
+	for _, in := range inputs {
+		toks := Tokenize(in)
+		var sb strings.Builder
+		pos := 0
+		for _, tok := range toks {
+			if tok.Pos != pos || tok.End != pos+len(tok.Text) || tok.Text != in[tok.Pos:tok.End] {
+				t.Errorf("`%s`: bad span on %#v", in, tok)
+			}
+			sb.WriteString(tok.Text)
+			pos = tok.End
+		}
+		if sb.String() != in {
+			t.Errorf("`%s`: tokens reassemble to `%s`", in, sb.String())
+		}
+	}
+}
+
+func TestTokenizeKinds(t *testing.T) {
+	data := []struct {
+		input    string
+		expected []TokenKind
+	}{
+		{`foo`, []TokenKind{TokLiteral}},
+		{` foo `, []TokenKind{TokSpace, TokLiteral, TokSpace}},
+		{`tags:(a OR "b c")`, []TokenKind{TokLiteral, TokColon, TokLParen, TokLiteral, TokSpace, TokOr, TokSpace, TokQuoted, TokRParen}},
+		{`-x^2 +y~`, []TokenKind{TokMinus, TokLiteral, TokBoost, TokSpace, TokPlus, TokLiteral, TokFuzzy}},
+		{`n:[1 TO 5}`, []TokenKind{TokLiteral, TokColon, TokLSquare, TokLiteral, TokSpace, TokTo, TokSpace, TokLiteral, TokRBrace}},
+		{`a "oops`, []TokenKind{TokLiteral, TokSpace, TokError}},
+	}
+
+	for _, dat := range data {
+		toks := Tokenize(dat.input)
+		got := make([]TokenKind, len(toks))
+		for i, tok := range toks {
+			got[i] = tok.Kind
+		}
+		if !reflect.DeepEqual(dat.expected, got) {
+			t.Errorf("Expected %v, got %v: for `%s`", dat.expected, got, dat.input)
+		}
+	}
+
+	toks := Tokenize(`a "oops`)
+	if last := toks[len(toks)-1]; last.Err != "unclosed quote" || last.Text != `"oops` {
+		t.Errorf("unexpected error token %#v", last)
+	}
+}
@@ -0,0 +1,320 @@
+package qs
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSON encoding of syntax trees, for storing parsed queries or passing them
+// to other systems (eg a query builder UI).
+// The format is described in tree-json.md.
+
+// TreeVersion is the version of the JSON tree format written by
+// MarshalTree. UnmarshalTree accepts this version and any earlier ones.
+const TreeVersion = 3
+
+// nodeVersions holds the format version which introduced each node type,
+// for those added since version 1
+var nodeVersions = map[string]int{
+	"macro":  2,
+	"lookup": 3,
+}
+
+type jsonTree struct {
+	Version int       `json:"version"`
+	Tree    *jsonNode `json:"tree"`
+}
+
+type jsonNode struct {
+	Type string `json:"type"`
+	Span []int  `json:"span,omitempty"`
+
+	Op       string      `json:"op,omitempty"`
+	Field    string      `json:"field,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Args     *[]string   `json:"args,omitempty"`
+	Text     *string     `json:"text,omitempty"`
+	Fuzzy    *int        `json:"fuzziness,omitempty"`
+	Boost    *float64    `json:"boost,omitempty"`
+	BoostPos *int        `json:"boost_pos,omitempty"`
+	Min      string      `json:"min,omitempty"`
+	Max      string      `json:"max,omitempty"`
+	MinIncl  *bool       `json:"min_inclusive,omitempty"`
+	MaxIncl  *bool       `json:"max_inclusive,omitempty"`
+	MinQuote bool        `json:"min_quoted,omitempty"`
+	MaxQuote bool        `json:"max_quoted,omitempty"`
+	X        *jsonNode   `json:"x,omitempty"`
+	Clauses  []*jsonNode `json:"clauses,omitempty"`
+	Operands []*jsonNode `json:"operands,omitempty"`
+}
+
+// MarshalTree encodes a syntax tree as JSON. If withSpans is set, the
+// source positions of the nodes are included.
+func MarshalTree(n Node, withSpans bool) ([]byte, error) {
+	jn, err := encodeNode(n, withSpans)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&jsonTree{Version: TreeVersion, Tree: jn})
+}
+
+// UnmarshalTree decodes a syntax tree encoded by MarshalTree.
+// Trees written by older versions of MarshalTree are upgraded as needed.
+// The result can be compiled into a bleve query with Parser.Compile.
+func UnmarshalTree(data []byte) (Node, error) {
+	var jt jsonTree
+	if err := json.Unmarshal(data, &jt); err != nil {
+		return nil, err
+	}
+	switch {
+	case jt.Version < 1:
+		return nil, fmt.Errorf("missing or invalid tree version")
+	case jt.Version > TreeVersion:
+		return nil, fmt.Errorf("tree version %d is newer than supported (%d)", jt.Version, TreeVersion)
+	}
+	if jt.Tree == nil {
+		return nil, fmt.Errorf("missing tree")
+	}
+	return decodeNode(jt.Tree, jt.Version)
+}
+
+func encodeNode(n Node, withSpans bool) (*jsonNode, error) {
+	jn := &jsonNode{}
+	if withSpans {
+		jn.Span = []int{n.Pos(), n.End()}
+	}
+
+	var err error
+	switch n := n.(type) {
+	case *List:
+		jn.Type = "list"
+		jn.Clauses, err = encodeNodes(n.Clauses, withSpans)
+	case *BoolExpr:
+		jn.Type = "bool"
+		jn.Op = "OR"
+		if n.Op == AND {
+			jn.Op = "AND"
+		}
+		jn.Operands, err = encodeNodes(n.Operands, withSpans)
+	case *NotExpr:
+		jn.Type = "not"
+		jn.X, err = encodeNode(n.X, withSpans)
+	case *PrefixExpr:
+		jn.Type = "prefix"
+		jn.Op = string(n.Op)
+		jn.X, err = encodeNode(n.X, withSpans)
+	case *FieldExpr:
+		jn.Type = "field"
+		jn.Field = n.Field
+		jn.X, err = encodeNode(n.X, withSpans)
+	case *BoostExpr:
+		jn.Type = "boost"
+		boost := n.Boost
+		jn.Boost = &boost
+		if withSpans {
+			pos := n.BoostPos
+			jn.BoostPos = &pos
+		}
+		jn.X, err = encodeNode(n.X, withSpans)
+	case *Group:
+		jn.Type = "group"
+		jn.X, err = encodeNode(n.X, withSpans)
+	case *MacroExpr:
+		jn.Type = "macro"
+		jn.Name = n.Name
+		// @a() and @a are different
+		if n.Args != nil {
+			args := n.Args
+			jn.Args = &args
+		}
+		jn.X, err = encodeNode(n.X, withSpans)
+	case *TermsLookup:
+		jn.Type = "lookup"
+		jn.Name = n.Name
+	case *Term:
+		jn.Type = "term"
+		text := n.Text
+		jn.Text = &text
+		if n.Fuzzy {
+			fuzz := n.Fuzziness
+			jn.Fuzzy = &fuzz
+		}
+	case *Phrase:
+		jn.Type = "phrase"
+		text := n.Text
+		jn.Text = &text
+	case *RangeExpr:
+		jn.Type = "range"
+		jn.Min, jn.Max = n.Min, n.Max
+		minIncl, maxIncl := n.MinInclusive, n.MaxInclusive
+		jn.MinIncl, jn.MaxIncl = &minIncl, &maxIncl
+		jn.MinQuote, jn.MaxQuote = n.MinQuoted, n.MaxQuoted
+	default:
+		return nil, fmt.Errorf("can't encode %T", n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jn, nil
+}
+
+func encodeNodes(nodes []Node, withSpans bool) ([]*jsonNode, error) {
+	out := make([]*jsonNode, len(nodes))
+	for i, n := range nodes {
+		jn, err := encodeNode(n, withSpans)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = jn
+	}
+	return out, nil
+}
+
+// decodeNode decodes a node from a tree of the given format version
+func decodeNode(jn *jsonNode, version int) (Node, error) {
+	if v, ok := nodeVersions[jn.Type]; ok && v > version {
+		return nil, fmt.Errorf("%s: not supported in tree version %d", jn.Type, version)
+	}
+	var span Span
+	switch len(jn.Span) {
+	case 0:
+	case 2:
+		span = Span{jn.Span[0], jn.Span[1]}
+	default:
+		return nil, fmt.Errorf("%s: bad span", jn.Type)
+	}
+
+	// most nodes have a single child
+	var x Node
+	switch jn.Type {
+	case "not", "prefix", "field", "boost", "group", "macro":
+		if jn.X == nil {
+			return nil, fmt.Errorf("%s: missing x", jn.Type)
+		}
+		var err error
+		x, err = decodeNode(jn.X, version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch jn.Type {
+	case "list":
+		clauses, err := decodeNodes(jn.Clauses, version)
+		if err != nil {
+			return nil, err
+		}
+		return &List{Span: span, Clauses: clauses}, nil
+	case "bool":
+		b := &BoolExpr{Span: span}
+		switch jn.Op {
+		case "OR":
+			b.Op = OR
+		case "AND":
+			b.Op = AND
+		default:
+			return nil, fmt.Errorf("bool: bad op '%s'", jn.Op)
+		}
+		var err error
+		b.Operands, err = decodeNodes(jn.Operands, version)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "not":
+		return &NotExpr{Span: span, X: x}, nil
+	case "prefix":
+		if jn.Op != "+" && jn.Op != "-" {
+			return nil, fmt.Errorf("prefix: bad op '%s'", jn.Op)
+		}
+		return &PrefixExpr{Span: span, Op: rune(jn.Op[0]), X: x}, nil
+	case "field":
+		if jn.Field == "" {
+			return nil, fmt.Errorf("field: missing field name")
+		}
+		return &FieldExpr{Span: span, Field: jn.Field, X: x}, nil
+	case "boost":
+		if jn.Boost == nil {
+			return nil, fmt.Errorf("boost: missing boost value")
+		}
+		b := &BoostExpr{Span: span, X: x, Boost: *jn.Boost}
+		if jn.BoostPos != nil {
+			b.BoostPos = *jn.BoostPos
+		}
+		return b, nil
+	case "group":
+		l, ok := x.(*List)
+		if !ok {
+			return nil, fmt.Errorf("group: x must be a list")
+		}
+		return &Group{Span: span, X: l}, nil
+	case "macro":
+		if jn.Name == "" {
+			return nil, fmt.Errorf("macro: missing name")
+		}
+		l, ok := x.(*List)
+		if !ok {
+			return nil, fmt.Errorf("macro: x must be a list")
+		}
+		m := &MacroExpr{Span: span, Name: jn.Name, X: l}
+		if jn.Args != nil {
+			m.Args = *jn.Args
+			if m.Args == nil {
+				m.Args = []string{}
+			}
+		}
+		return m, nil
+	case "lookup":
+		if jn.Name == "" {
+			return nil, fmt.Errorf("lookup: missing name")
+		}
+		return &TermsLookup{Span: span, Name: jn.Name}, nil
+	case "term":
+		if jn.Text == nil {
+			return nil, fmt.Errorf("term: missing text")
+		}
+		t := &Term{Span: span, Text: *jn.Text}
+		if jn.Fuzzy != nil {
+			t.Fuzzy = true
+			t.Fuzziness = *jn.Fuzzy
+		}
+		// so that the tree can be formatted
+		if !writable(t) {
+			return nil, fmt.Errorf("term: '%s' can't be a wildcard or fuzzy term", t.Text)
+		}
+		return t, nil
+	case "phrase":
+		if jn.Text == nil {
+			return nil, fmt.Errorf("phrase: missing text")
+		}
+		return &Phrase{Span: span, Text: *jn.Text}, nil
+	case "range":
+		r := &RangeExpr{Span: span, Min: jn.Min, Max: jn.Max, MinQuoted: jn.MinQuote, MaxQuoted: jn.MaxQuote}
+		if jn.MinIncl != nil {
+			r.MinInclusive = *jn.MinIncl
+		}
+		if jn.MaxIncl != nil {
+			r.MaxInclusive = *jn.MaxIncl
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown node type '%s'", jn.Type)
+}
+
+func decodeNodes(jns []*jsonNode, version int) ([]Node, error) {
+	if jns == nil {
+		return nil, nil
+	}
+	out := make([]Node, len(jns))
+	for i, jn := range jns {
+		if jn == nil {
+			return nil, fmt.Errorf("null node")
+		}
+		n, err := decodeNode(jn, version)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
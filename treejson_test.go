@@ -0,0 +1,145 @@
+package qs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTreeJSONRoundTrip(t *testing.T) {
+	inputs := []string{
+		``,
+		`grapefruit`,
+		`tags:citrus -"navel orange"^2`,
+		`(shaddock OR pomelo) AND (family:rutaceae AND NOT genus:fortunella) colour:(greenish yellowish)`,
+		`+alice OR -bob NOT +chuck`,
+		`f??t ant* colour~ colour~0 colour~2 ""`,
+		`pubdate:[2010-01-01 TO 2011-01-01} shoesize:{0 TO 16] score:<=100 temp:[TO 5] x:>=3`,
+		`x:["$a" TO "b c"] y:>"$b"`,
+		`()^3`,
+	}
+
+	for _, in := range inputs {
+		tree, err := ParseTree(in)
+		if err != nil {
+			t.Fatalf("`%s`: %s", in, err)
+		}
+		for _, withSpans := range []bool{true, false} {
+			data, err := MarshalTree(tree, withSpans)
+			if err != nil {
+				t.Fatalf("`%s`: %s", in, err)
+			}
+			got, err := UnmarshalTree(data)
+			if err != nil {
+				t.Fatalf("`%s`: %s (%s)", in, err, data)
+			}
+			if withSpans && !reflect.DeepEqual(got, tree) {
+				t.Errorf("`%s`: round trip via %s gave different tree", in, data)
+			}
+			if Format(got) != Format(tree) {
+				t.Errorf("`%s`: round trip gave `%s`", in, Format(got))
+			}
+
+			// should compile to the same query as the original string
+			expected, err := Parse(in)
+			if err != nil {
+				continue
+			}
+			var p Parser
+			q, err := p.Compile(got)
+			if err != nil {
+				t.Fatalf("`%s`: %s", in, err)
+			}
+			if !reflect.DeepEqual(q, expected) {
+				t.Errorf("`%s`: decoded tree compiles differently", in)
+			}
+		}
+	}
+}
+
+func TestTreeJSONFormat(t *testing.T) {
+	tree, _ := ParseTree(`tags:citrus -"navel orange"^2`)
+	data, err := MarshalTree(tree, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":3,"tree":{"type":"list","span":[0,29],"clauses":[` +
+		`{"type":"field","span":[0,11],"field":"tags","x":{"type":"term","span":[5,11],"text":"citrus"}},` +
+		`{"type":"prefix","span":[12,29],"op":"-","x":{"type":"boost","span":[13,29],"boost":2,"boost_pos":27,"x":{"type":"phrase","span":[13,27],"text":"navel orange"}}}]}}`
+	if string(data) != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, data)
+	}
+}
+
+func TestTreeJSONErrors(t *testing.T) {
+	bad := []string{
+		`{"tree":{"type":"list"}}`,
+		`{"version":99,"tree":{"type":"list"}}`,
+		`{"version":1}`,
+		`{"version":1,"tree":{"type":"wibble"}}`,
+		`{"version":1,"tree":{"type":"not"}}`,
+		`{"version":1,"tree":{"type":"bool","op":"XOR"}}`,
+		`{"version":1,"tree":{"type":"prefix","op":"!","x":{"type":"term","text":"a"}}}`,
+		`{"version":1,"tree":{"type":"group","x":{"type":"term","text":"a"}}}`,
+		`{"version":1,"tree":{"type":"term"}}`,
+		`{"version":1,"tree":{"type":"list","clauses":[null]}}`,
+		`{"version":1,"tree":{"type":"term","text":"a","span":[1]}}`,
+		// can't be written as query strings
+		`{"version":1,"tree":{"type":"term","text":"a b","fuzziness":1}}`,
+		`{"version":1,"tree":{"type":"term","text":"a b*"}}`,
+		// node types newer than the tree
+		`{"version":1,"tree":{"type":"list","clauses":[{"type":"macro","name":"a","x":{"type":"list"}}]}}`,
+		`{"version":2,"tree":{"type":"list","clauses":[{"type":"lookup","name":"a"}]}}`,
+	}
+	for _, in := range bad {
+		if _, err := UnmarshalTree([]byte(in)); err == nil {
+			t.Errorf("expected error for %s", in)
+		}
+	}
+}
+
+func TestTreeJSONMacro(t *testing.T) {
+	p := Parser{Macros: testMacros, Lists: TermListMap{}}
+	tree, err := p.ParseTree(`tags:@citrus_fruits @either(a "b c") @either() -id:@list(blocked)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalTree(tree, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalTree(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tree) {
+		t.Errorf("round trip via %s gave different tree", data)
+	}
+	if Format(got) != Format(tree) {
+		t.Errorf("round trip gave `%s`", Format(got))
+	}
+}
+
+// trees written by older versions can still be read
+func TestTreeJSONOldVersion(t *testing.T) {
+	v1 := `{"version":1,"tree":{"type":"list","clauses":[` +
+		`{"type":"field","field":"tags","x":{"type":"term","text":"citrus"}},` +
+		`{"type":"prefix","op":"-","x":{"type":"phrase","text":"navel orange"}}]}}`
+	got, err := UnmarshalTree([]byte(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Format(got) != `tags:citrus -"navel orange"` {
+		t.Errorf("unexpected tree `%s`", Format(got))
+	}
+	data, err := MarshalTree(got, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := UnmarshalTree(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, got) {
+		t.Errorf("round trip via %s gave different tree", data)
+	}
+}
@@ -0,0 +1,211 @@
+package qs
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(n Node) (w Visitor)
+}
+
+// Walk traverses a syntax tree in depth-first order: It starts by calling
+// v.Visit(n); n must not be nil. If the visitor w returned by v.Visit(n)
+// is not nil, Walk is invoked recursively with visitor w for each of the
+// non-nil children of n, followed by a call of w.Visit(nil).
+func Walk(v Visitor, n Node) {
+	if v = v.Visit(n); v == nil {
+		return
+	}
+
+	switch n := n.(type) {
+	case *List:
+		for _, c := range n.Clauses {
+			Walk(v, c)
+		}
+	case *BoolExpr:
+		for _, c := range n.Operands {
+			Walk(v, c)
+		}
+	case *NotExpr:
+		Walk(v, n.X)
+	case *PrefixExpr:
+		Walk(v, n.X)
+	case *FieldExpr:
+		Walk(v, n.X)
+	case *BoostExpr:
+		Walk(v, n.X)
+	case *Group:
+		Walk(v, n.X)
+	case *MacroExpr:
+		Walk(v, n.X)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(n Node) Visitor {
+	if f(n) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a syntax tree in depth-first order: It starts by
+// calling f(n); n must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the children of n, followed by a call of
+// f(nil).
+//
+// For example, to collect all the terms in a query:
+//
+//   terms := []string{}
+//   qs.Inspect(tree, func(n qs.Node) bool {
+//       if t, ok := n.(*qs.Term); ok {
+//           terms = append(terms, t.Text)
+//       }
+//       return true
+//   })
+func Inspect(n Node, f func(Node) bool) {
+	Walk(inspector(f), n)
+}
+
+// Rewrite returns a copy of a syntax tree with nodes replaced by f.
+//
+// The tree is traversed depth first, and f is called for each node after
+// its children have been rewritten. f returns the node to use in its
+// place, which may be the node itself, a new node, or nil to remove it
+// altogether. Removing the only child of a node removes the parent too
+// (so removing the term from `-foo` removes the whole clause), and an AND or
+// OR left with a single operand is replaced by that operand. A lone `+` or
+// `-` operand is wrapped in a Group instead, so that it doesn't take on a
+// different meaning in the enclosing list (`foo AND -bar` becomes `(-bar)`,
+// not `-bar`), and f is called for the new Group in place of the AND or OR.
+//
+// The original tree is not modified: nodes with rewritten children are
+// shallow copies, and untouched subtrees are shared with the original.
+// Positions are carried over from the original nodes, so they still refer
+// to the original query string. Nodes created by f can leave their Span
+// empty.
+//
+// The rewritten tree can be turned into a bleve query with Parser.Compile,
+// or back into a query string with Format.
+func Rewrite(n Node, f func(Node) Node) Node {
+	switch orig := n.(type) {
+	case *List:
+		clauses, changed := rewriteNodes(orig.Clauses, f)
+		if changed {
+			cpy := *orig
+			cpy.Clauses = clauses
+			n = &cpy
+		}
+	case *BoolExpr:
+		operands, changed := rewriteNodes(orig.Operands, f)
+		if changed {
+			switch len(operands) {
+			case 0:
+				return nil
+			case 1:
+				if pe, ok := operands[0].(*PrefixExpr); ok {
+					return f(&Group{Span: orig.Span, X: &List{Span: orig.Span, Clauses: []Node{pe}}})
+				}
+				return operands[0]
+			}
+			cpy := *orig
+			cpy.Operands = operands
+			n = &cpy
+		}
+	case *NotExpr:
+		x := Rewrite(orig.X, f)
+		if x == nil {
+			return nil
+		}
+		if x != orig.X {
+			cpy := *orig
+			cpy.X = x
+			n = &cpy
+		}
+	case *PrefixExpr:
+		x := Rewrite(orig.X, f)
+		if x == nil {
+			return nil
+		}
+		if x != orig.X {
+			cpy := *orig
+			cpy.X = x
+			n = &cpy
+		}
+	case *FieldExpr:
+		x := Rewrite(orig.X, f)
+		if x == nil {
+			return nil
+		}
+		if x != orig.X {
+			cpy := *orig
+			cpy.X = x
+			n = &cpy
+		}
+	case *BoostExpr:
+		x := Rewrite(orig.X, f)
+		if x == nil {
+			return nil
+		}
+		if x != orig.X {
+			cpy := *orig
+			cpy.X = x
+			n = &cpy
+		}
+	case *Group:
+		x := Rewrite(orig.X, f)
+		if x == nil {
+			return nil
+		}
+		if x != Node(orig.X) {
+			cpy := *orig
+			if l, ok := x.(*List); ok {
+				cpy.X = l
+			} else {
+				cpy.X = &List{Span: Span{x.Pos(), x.End()}, Clauses: []Node{x}}
+			}
+			if len(cpy.X.Clauses) == 0 {
+				// nothing left in the parentheses
+				return nil
+			}
+			n = &cpy
+		}
+	case *MacroExpr:
+		x := Rewrite(orig.X, f)
+		if x == nil {
+			return nil
+		}
+		if x != Node(orig.X) {
+			cpy := *orig
+			if l, ok := x.(*List); ok {
+				cpy.X = l
+			} else {
+				cpy.X = &List{Span: Span{x.Pos(), x.End()}, Clauses: []Node{x}}
+			}
+			if len(cpy.X.Clauses) == 0 {
+				return nil
+			}
+			n = &cpy
+		}
+	}
+	return f(n)
+}
+
+// rewriteNodes rewrites a slice of nodes, dropping any removed by f.
+// The original slice is left untouched.
+func rewriteNodes(nodes []Node, f func(Node) Node) ([]Node, bool) {
+	out := make([]Node, 0, len(nodes))
+	changed := false
+	for _, n := range nodes {
+		n2 := Rewrite(n, f)
+		if n2 != n {
+			changed = true
+		}
+		if n2 != nil {
+			out = append(out, n2)
+		}
+	}
+	return out, changed
+}
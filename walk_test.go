@@ -0,0 +1,164 @@
+package qs
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ExampleInspect() {
+	tree, _ := ParseTree(`tags:(lemon OR lime) "navel orange" -grapefruit`)
+
+	terms := []string{}
+	Inspect(tree, func(n Node) bool {
+		switch n := n.(type) {
+		case *Term:
+			terms = append(terms, n.Text)
+		case *Phrase:
+			terms = append(terms, n.Text)
+		}
+		return true
+	})
+	fmt.Println(strings.Join(terms, ","))
+	// Output: lemon,lime,navel orange,grapefruit
+}
+
+func ExampleRewrite() {
+	tree, _ := ParseTree(`colour:orange^2 "navel orange" AND -colour:"pale green"`)
+
+	// rename a field, strip boosts and swap single-word phrases for terms
+	tree = Rewrite(tree, func(n Node) Node {
+		switch n := n.(type) {
+		case *FieldExpr:
+			if n.Field == "colour" {
+				return &FieldExpr{Span: n.Span, Field: "color", X: n.X}
+			}
+		case *BoostExpr:
+			return n.X
+		case *Phrase:
+			if !strings.Contains(n.Text, " ") {
+				return &Term{Span: n.Span, Text: n.Text}
+			}
+		}
+		return n
+	})
+	fmt.Println(Format(tree))
+
+	// and compile it into a bleve query
+	var p Parser
+	q, _ := p.Compile(tree)
+	fmt.Printf("%T\n", q)
+	// Output:
+	// color:orange "navel orange" AND -color:"pale green"
+	// *query.BooleanQuery
+}
+
+type recordingVisitor struct {
+	visited *[]string
+}
+
+func (v recordingVisitor) Visit(n Node) Visitor {
+	if n == nil {
+		*v.visited = append(*v.visited, "end")
+		return nil
+	}
+	*v.visited = append(*v.visited, fmt.Sprintf("%T", n))
+	if _, ok := n.(*Group); ok {
+		return nil // don't descend into groups
+	}
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	tree, err := ParseTree(`a OR NOT b (c)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	visited := []string{}
+	Walk(recordingVisitor{&visited}, tree)
+	expected := []string{"*qs.List", "*qs.BoolExpr", "*qs.Term", "end", "*qs.NotExpr", "*qs.Term", "end", "end", "end", "*qs.Group", "end"}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Expected %v, got %v", expected, visited)
+	}
+}
+
+func TestRewriteRemove(t *testing.T) {
+	data := []struct {
+		input    string
+		expected string
+	}{
+		{`foo bar`, `bar`},
+		{`foo AND bar`, `bar`},
+		{`foo AND bar AND baz`, `bar AND baz`},
+		{`-foo bar`, `bar`},
+		{`x:(foo) bar`, `bar`},
+		{`x:(foo baz)^2`, `x:(baz)^2`},
+		{`foo`, ``},
+		{`foo AND -bar`, `(-bar)`},
+		{`baz foo OR +bar`, `baz (+bar)`},
+	}
+
+	for _, dat := range data {
+		tree, err := ParseTree(dat.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := Format(tree)
+		got := Rewrite(tree, func(n Node) Node {
+			if t, ok := n.(*Term); ok && t.Text == "foo" {
+				return nil
+			}
+			return n
+		})
+		if Format(got) != dat.expected {
+			t.Errorf("`%s`: expected `%s`, got `%s`", dat.input, dat.expected, Format(got))
+		}
+		// original should be untouched
+		if Format(tree) != before {
+			t.Errorf("`%s`: original modified to `%s`", dat.input, Format(tree))
+		}
+	}
+}
+
+func TestRewritePositions(t *testing.T) {
+	in := `alpha AND (beta gamma)`
+	tree, _ := ParseTree(in)
+	tree = Rewrite(tree, func(n Node) Node {
+		if t, ok := n.(*Term); ok && t.Text == "beta" {
+			return nil
+		}
+		return n
+	})
+	Inspect(tree, func(n Node) bool {
+		if term, ok := n.(*Term); ok && in[term.Pos():term.End()] != term.Text {
+			t.Errorf("position not preserved for `%s`", term.Text)
+		}
+		return true
+	})
+	if got := Format(tree); got != `alpha AND (gamma)` {
+		t.Errorf("Expected `alpha AND (gamma)`, got `%s`", got)
+	}
+}
+
+// the group which replaces a collapsed AND or OR goes through f too
+func TestRewriteCollapse(t *testing.T) {
+	tree, _ := ParseTree(`baz (foo AND -bar)`)
+	groups := 0
+	got := Rewrite(tree, func(n Node) Node {
+		if t, ok := n.(*Term); ok && t.Text == "foo" {
+			return nil
+		}
+		if _, ok := n.(*Group); ok {
+			groups++
+		}
+		return n
+	})
+	if Format(got) != `baz ((-bar))` {
+		t.Errorf("expected `baz ((-bar))`, got `%s`", Format(got))
+	}
+	// the new group, and the original one around it
+	if groups != 2 {
+		t.Errorf("expected f to see 2 groups, got %d", groups)
+	}
+}